@@ -1,12 +1,17 @@
 package buildah
 
 import (
+	"archive/tar"
+	_ "crypto/sha256" // for digest.SHA256
+	_ "crypto/sha512" // for digest.SHA512
 	"io"
+	"io/ioutil"
 	"net/http"
 	"net/url"
 	"os"
 	"path"
 	"path/filepath"
+	"strconv"
 	"strings"
 	"syscall"
 	"time"
@@ -17,17 +22,38 @@ import (
 	"github.com/containers/storage/pkg/fileutils"
 	"github.com/containers/storage/pkg/idtools"
 	"github.com/containers/storage/pkg/system"
+	digest "github.com/opencontainers/go-digest"
 	"github.com/opencontainers/runtime-spec/specs-go"
 	"github.com/pkg/errors"
 	"github.com/sirupsen/logrus"
 )
 
+const (
+	// ChownSourceTarget indicates that user and group names in a Chown
+	// spec should be looked up using the container's /etc/passwd and
+	// /etc/group files.  This is the default.
+	ChownSourceTarget = "target"
+	// ChownSourceHost indicates that user and group names in a Chown spec
+	// should be looked up using the host's /etc/passwd and /etc/group
+	// files, for use when the container doesn't have them yet.
+	ChownSourceHost = "host"
+)
+
 // AddAndCopyOptions holds options for add and copy commands.
 type AddAndCopyOptions struct {
 	// Chown is a spec for the user who should be given ownership over the
 	// newly-added content, potentially overriding permissions which would
 	// otherwise match those of local files and directories being copied.
 	Chown string
+	// ChownSource is either ChownSourceTarget or ChownSourceHost, and
+	// controls where user and group names in Chown are looked up.  If it
+	// is not set, ChownSourceTarget is assumed.
+	ChownSource string
+	// Chmod is an octal mode which should be applied to the newly-added
+	// content, overriding permissions which would otherwise match those
+	// of local files and directories being copied.  It is not applied to
+	// the contents of archives which are extracted, or to symbolic links.
+	Chmod string
 	// All of the data being copied will pass through Hasher, if set.
 	// If the sources are URLs or files, their contents will be passed to
 	// Hasher.
@@ -42,22 +68,37 @@ type AddAndCopyOptions struct {
 	// another container, and need ownerships to be mapped from the host to
 	// that container's values before copying them into the container.
 	IDMappingOptions *IDMappingOptions
+	// Checksum is the digest, in "algorithm:hex" form, which the contents
+	// of sources which are URLs are expected to have.  If any of them
+	// don't match, the copy fails, and the mismatched contents are not
+	// left in the container.  Only sha256 and sha512 digests are
+	// supported, and it can not be used with sources which aren't URLs.
+	Checksum string
 }
 
 // addURL copies the contents of the source URL to the destination.  This is
 // its own function so that deferred closes happen after we're done pulling
-// down each item of potentially many.
-func addURL(destination, srcurl string, owner idtools.IDPair, hasher io.Writer) error {
+// down each item of potentially many.  If checksum is set, the contents must
+// match it, or the destination is left untouched.
+func addURL(destination, srcurl string, owner idtools.IDPair, hasher io.Writer, checksum digest.Digest) error {
 	logrus.Debugf("saving %q to %q", srcurl, destination)
 	resp, err := http.Get(srcurl)
 	if err != nil {
 		return errors.Wrapf(err, "error getting %q", srcurl)
 	}
 	defer resp.Body.Close()
-	f, err := os.Create(destination)
+	f, err := ioutil.TempFile(filepath.Dir(destination), ".buildah-add-")
 	if err != nil {
-		return errors.Wrapf(err, "error creating %q", destination)
+		return errors.Wrapf(err, "error creating temporary file for %q", destination)
 	}
+	renamed := false
+	defer func() {
+		if !renamed {
+			if err2 := os.Remove(f.Name()); err2 != nil {
+				logrus.Debugf("error removing temporary file %q: %v", f.Name(), err2)
+			}
+		}
+	}()
 	if err = f.Chown(owner.UID, owner.GID); err != nil {
 		return errors.Wrapf(err, "error setting owner of %q to %d:%d", destination, owner.UID, owner.GID)
 	}
@@ -66,6 +107,9 @@ func addURL(destination, srcurl string, owner idtools.IDPair, hasher io.Writer)
 			logrus.Debugf("error parsing Last-Modified time %q: %v", last, err2)
 		} else {
 			defer func() {
+				if !renamed {
+					return
+				}
 				if err3 := os.Chtimes(destination, time.Now(), mtime); err3 != nil {
 					logrus.Debugf("error setting mtime on %q to Last-Modified time %q: %v", destination, last, err3)
 				}
@@ -77,6 +121,11 @@ func addURL(destination, srcurl string, owner idtools.IDPair, hasher io.Writer)
 	if hasher != nil {
 		bodyReader = io.TeeReader(bodyReader, hasher)
 	}
+	var verifier digest.Verifier
+	if checksum != "" {
+		verifier = checksum.Verifier()
+		bodyReader = io.TeeReader(bodyReader, verifier)
+	}
 	n, err := io.Copy(f, bodyReader)
 	if err != nil {
 		return errors.Wrapf(err, "error reading contents for %q from %q", destination, srcurl)
@@ -84,12 +133,37 @@ func addURL(destination, srcurl string, owner idtools.IDPair, hasher io.Writer)
 	if resp.ContentLength >= 0 && n != resp.ContentLength {
 		return errors.Errorf("error reading contents for %q from %q: wrong length (%d != %d)", destination, srcurl, n, resp.ContentLength)
 	}
+	if verifier != nil && !verifier.Verified() {
+		return errors.Errorf("error verifying contents of %q: checksum did not match %q", srcurl, checksum.String())
+	}
 	if err := f.Chmod(0600); err != nil {
 		return errors.Wrapf(err, "error setting permissions on %q", destination)
 	}
+	if err := os.Rename(f.Name(), destination); err != nil {
+		return errors.Wrapf(err, "error saving contents for %q from %q", destination, srcurl)
+	}
+	renamed = true
 	return nil
 }
 
+// parseChecksum parses the digest which the contents of URLs are expected to
+// have, if one was specified.
+func parseChecksum(checksum string) (digest.Digest, error) {
+	if checksum == "" {
+		return "", nil
+	}
+	d, err := digest.Parse(checksum)
+	if err != nil {
+		return "", errors.Wrapf(err, "error parsing checksum %q", checksum)
+	}
+	switch d.Algorithm() {
+	case digest.SHA256, digest.SHA512:
+	default:
+		return "", errors.Errorf("unsupported checksum algorithm %q: only %q and %q are supported", d.Algorithm(), digest.SHA256, digest.SHA512)
+	}
+	return d, nil
+}
+
 // Add copies the contents of the specified sources into the container's root
 // filesystem, optionally extracting contents of local files that look like
 // non-empty archives.
@@ -98,6 +172,24 @@ func (b *Builder) Add(destination string, extract bool, options AddAndCopyOption
 	if err != nil {
 		return err
 	}
+	chmod, err := parseChmod(options.Chmod)
+	if err != nil {
+		return err
+	}
+	if options.ChownSource != "" && options.ChownSource != ChownSourceTarget && options.ChownSource != ChownSourceHost {
+		return errors.Errorf("invalid source %q for user and group names, expected %q or %q", options.ChownSource, ChownSourceTarget, ChownSourceHost)
+	}
+	checksum, err := parseChecksum(options.Checksum)
+	if err != nil {
+		return err
+	}
+	if checksum != "" {
+		for _, src := range source {
+			if !strings.HasPrefix(src, "http://") && !strings.HasPrefix(src, "https://") {
+				return errors.Errorf("checksum %q can only be verified for URLs, not %q", options.Checksum, src)
+			}
+		}
+	}
 	mountPoint, err := b.Mount(b.MountLabel)
 	if err != nil {
 		return err
@@ -108,7 +200,11 @@ func (b *Builder) Add(destination string, extract bool, options AddAndCopyOption
 		}
 	}()
 	// Find out which user (and group) the destination should belong to.
-	user, _, err := b.user(mountPoint, options.Chown)
+	userRoot := mountPoint
+	if options.Chown != "" && options.ChownSource == ChownSourceHost {
+		userRoot = string(os.PathSeparator)
+	}
+	user, _, err := b.user(userRoot, options.Chown)
 	if err != nil {
 		return err
 	}
@@ -156,10 +252,10 @@ func (b *Builder) Add(destination string, extract bool, options AddAndCopyOption
 	if len(source) > 1 && (destfi == nil || !destfi.IsDir()) {
 		return errors.Errorf("destination %q is not a directory", dest)
 	}
-	copyFileWithTar := b.copyFileWithTar(options.IDMappingOptions, &containerOwner, options.Hasher)
-	copyWithTar := b.copyWithTar(options.IDMappingOptions, &containerOwner, options.Hasher)
+	copyFileWithTar := preserveHardlinks(chmodAfterCopy(b.copyFileWithTar(options.IDMappingOptions, &containerOwner, options.Hasher), chmod), mountPoint, options.Hasher)
+	copyWithTar := chmodAfterCopy(b.copyWithTar(options.IDMappingOptions, &containerOwner, options.Hasher), chmod)
 	untarPath := b.untarPath(nil, options.Hasher)
-	err = addHelper(excludes, extract, dest, destfi, hostOwner, options, copyFileWithTar, copyWithTar, untarPath, source...)
+	err = addHelper(excludes, extract, dest, destfi, hostOwner, chmod, checksum, options, copyFileWithTar, copyWithTar, untarPath, source...)
 	if err != nil {
 		return err
 	}
@@ -230,7 +326,7 @@ func dockerIgnoreMatcher(lines []string, contextDir string) (*fileutils.PatternM
 	return matcher, nil
 }
 
-func addHelper(excludes *fileutils.PatternMatcher, extract bool, dest string, destfi os.FileInfo, hostOwner idtools.IDPair, options AddAndCopyOptions, copyFileWithTar, copyWithTar, untarPath func(src, dest string) error, source ...string) error {
+func addHelper(excludes *fileutils.PatternMatcher, extract bool, dest string, destfi os.FileInfo, hostOwner idtools.IDPair, chmod *os.FileMode, checksum digest.Digest, options AddAndCopyOptions, copyFileWithTar, copyWithTar, untarPath func(src, dest string) error, source ...string) error {
 	for _, src := range source {
 		if strings.HasPrefix(src, "http://") || strings.HasPrefix(src, "https://") {
 			// We assume that source is a file, and we're copying
@@ -246,7 +342,7 @@ func addHelper(excludes *fileutils.PatternMatcher, extract bool, dest string, de
 			if destfi != nil && destfi.IsDir() {
 				d = filepath.Join(dest, path.Base(url.Path))
 			}
-			if err = addURL(d, src, hostOwner, options.Hasher); err != nil {
+			if err = addURL(d, src, hostOwner, options.Hasher, checksum); err != nil {
 				return err
 			}
 			continue
@@ -307,7 +403,7 @@ func addHelper(excludes *fileutils.PatternMatcher, extract bool, dest string, de
 						syscall.NsecToTimespec(mtime.Unix()),
 					}
 					if info.IsDir() {
-						return addHelperDirectory(esrc, path, filepath.Join(dest, fpath), info, hostOwner, times)
+						return addHelperDirectory(esrc, path, filepath.Join(dest, fpath), info, hostOwner, chmod, times)
 					}
 					if info.Mode()&os.ModeSymlink == os.ModeSymlink {
 						return addHelperSymlink(path, filepath.Join(dest, fpath), info, hostOwner, times)
@@ -352,8 +448,12 @@ func addHelper(excludes *fileutils.PatternMatcher, extract bool, dest string, de
 	return nil
 }
 
-func addHelperDirectory(esrc, path, dest string, info os.FileInfo, hostOwner idtools.IDPair, times []syscall.Timespec) error {
-	if err := idtools.MkdirAllAndChownNew(dest, info.Mode().Perm(), hostOwner); err != nil {
+func addHelperDirectory(esrc, path, dest string, info os.FileInfo, hostOwner idtools.IDPair, chmod *os.FileMode, times []syscall.Timespec) error {
+	mode := info.Mode().Perm()
+	if chmod != nil {
+		mode = *chmod
+	}
+	if err := idtools.MkdirAllAndChownNew(dest, mode, hostOwner); err != nil {
 		// discard only EEXIST on the top directory, which would have been created earlier in the caller
 		if !os.IsExist(err) || path != esrc {
 			return errors.Errorf("error creating directory %q", dest)
@@ -362,6 +462,11 @@ func addHelperDirectory(esrc, path, dest string, info os.FileInfo, hostOwner idt
 	if err := idtools.SafeLchown(dest, hostOwner.UID, hostOwner.GID); err != nil {
 		return errors.Wrapf(err, "error setting owner of directory %q to %d:%d", dest, hostOwner.UID, hostOwner.GID)
 	}
+	if chmod != nil {
+		if err := os.Chmod(dest, *chmod); err != nil {
+			return errors.Wrapf(err, "error setting permissions on directory %q", dest)
+		}
+	}
 	if err := system.LUtimesNano(dest, times); err != nil {
 		return errors.Wrapf(err, "error setting dates on directory %q", dest)
 	}
@@ -393,3 +498,122 @@ func addHelperSymlink(src, dest string, info os.FileInfo, hostOwner idtools.IDPa
 	logrus.Debugf("Symlink(%s, %s)", linkContents, dest)
 	return nil
 }
+
+// parseChmod parses an octal mode specification.  If spec is empty, it returns
+// nil.
+func parseChmod(spec string) (*os.FileMode, error) {
+	if spec == "" {
+		return nil, nil
+	}
+	mode, err := strconv.ParseUint(spec, 8, 32)
+	if err != nil || mode > 07777 {
+		return nil, errors.Errorf("invalid file mode %q: must be an octal value", spec)
+	}
+	fileMode := os.FileMode(mode&0777) | unixModeBits(mode)
+	return &fileMode, nil
+}
+
+// unixModeBits converts the setuid, setgid, and sticky bits in a numeric mode
+// into their os.FileMode equivalents.
+func unixModeBits(mode uint64) os.FileMode {
+	var fileMode os.FileMode
+	if mode&04000 != 0 {
+		fileMode |= os.ModeSetuid
+	}
+	if mode&02000 != 0 {
+		fileMode |= os.ModeSetgid
+	}
+	if mode&01000 != 0 {
+		fileMode |= os.ModeSticky
+	}
+	return fileMode
+}
+
+// chmodAfterCopy wraps a function which copies src to dest so that, if chmod
+// is not nil, everything which it copied has its permissions set to *chmod.
+func chmodAfterCopy(copier func(src, dest string) error, chmod *os.FileMode) func(src, dest string) error {
+	if chmod == nil {
+		return copier
+	}
+	return func(src, dest string) error {
+		if err := copier(src, dest); err != nil {
+			return err
+		}
+		return filepath.Walk(src, func(path string, info os.FileInfo, err error) error {
+			if err != nil {
+				return err
+			}
+			if info.Mode()&os.ModeSymlink == os.ModeSymlink || (path == src && info.IsDir()) {
+				// Leave symbolic links, and directories whose
+				// contents were copied into an existing
+				// location, alone.
+				return nil
+			}
+			rel, err := filepath.Rel(src, path)
+			if err != nil {
+				return errors.Wrapf(err, "error converting %s to a path relative to %s", path, src)
+			}
+			target := filepath.Join(dest, rel)
+			if err = os.Chmod(target, *chmod); err != nil {
+				return errors.Wrapf(err, "error setting permissions on %q", target)
+			}
+			return nil
+		})
+	}
+}
+
+// hardlinkKey identifies a file using the device and inode number which it is
+// stored at.
+type hardlinkKey struct {
+	dev, ino uint64
+}
+
+// preserveHardlinks wraps a function which copies a single file from src to
+// dest so that, if src has more than one link, and another link to it has
+// already been copied using the returned function, dest is created as a hard
+// link to that earlier copy instead of as a second copy of src's contents.
+// The names of links are written to hasher, relative to root, in place of
+// the contents that we didn't copy.
+func preserveHardlinks(copier func(src, dest string) error, root string, hasher io.Writer) func(src, dest string) error {
+	copied := make(map[hardlinkKey]string)
+	return func(src, dest string) error {
+		var st syscall.Stat_t
+		if err := syscall.Lstat(src, &st); err != nil || st.Nlink < 2 {
+			return copier(src, dest)
+		}
+		key := hardlinkKey{dev: uint64(st.Dev), ino: uint64(st.Ino)}
+		target, ok := copied[key]
+		if !ok {
+			if err := copier(src, dest); err != nil {
+				return err
+			}
+			copied[key] = dest
+			return nil
+		}
+		logrus.Debugf("linking %q to %q, a copy of another link to %q", dest, target, src)
+		if err := os.Remove(dest); err != nil && !os.IsNotExist(err) {
+			return errors.Wrapf(err, "error removing %q to replace it with a hard link", dest)
+		}
+		if err := os.Link(target, dest); err != nil {
+			return errors.Wrapf(err, "error creating hard link %q to %q", dest, target)
+		}
+		if hasher != nil {
+			name, err := filepath.Rel(root, dest)
+			if err != nil {
+				return errors.Wrapf(err, "error computing location of %q in container", dest)
+			}
+			linkname, err := filepath.Rel(root, target)
+			if err != nil {
+				return errors.Wrapf(err, "error computing location of %q in container", target)
+			}
+			tw := tar.NewWriter(hasher)
+			if err = tw.WriteHeader(&tar.Header{Typeflag: tar.TypeLink, Name: name, Linkname: linkname}); err != nil {
+				return errors.Wrapf(err, "error recording hard link %q to %q", dest, target)
+			}
+			if err = tw.Flush(); err != nil {
+				return errors.Wrapf(err, "error recording hard link %q to %q", dest, target)
+			}
+		}
+		return nil
+	}
+}
@@ -278,6 +278,12 @@ type CommonBuildOptions struct {
 	CPUPeriod uint64
 	// CPUQuota limits the CPU CFS (Completely Fair Scheduler) quota
 	CPUQuota int64
+	// CPURealtimePeriod is the length, in microseconds, of the period
+	// over which the CPU time available to realtime tasks is limited.
+	CPURealtimePeriod uint64
+	// CPURealtimeRuntime limits the CPU time, in microseconds, which
+	// realtime tasks can use during each period.
+	CPURealtimeRuntime int64
 	// CPUShares (relative weight
 	CPUShares uint64
 	// CPUSetCPUs in which to allow execution (0-3, 0,1)
@@ -350,6 +356,18 @@ type BuilderOptions struct {
 	// to store copies of layer blobs that we pull down, if any.  It should
 	// already exist.
 	BlobDirectory string
+	// MaxParallelDownloads is the maximum number of layer blobs which
+	// will be downloaded at the same time if we need to pull the image.
+	// If it is not set, the image library's default limit is used.
+	MaxParallelDownloads int
+	// Progress, if set, is called with reports of how much of each blob
+	// has been downloaded if we need to pull the image, no more often than once per ProgressInterval,
+	// or DefaultProgressInterval if that is not set.  The report for a
+	// blob's final bytes may be skipped.
+	Progress ProgressFunc
+	// ProgressInterval is the minimum amount of time between calls to
+	// Progress for a single blob.
+	ProgressInterval time.Duration
 	// Mount signals to NewBuilder() that the container should be mounted
 	// immediately.
 	Mount bool
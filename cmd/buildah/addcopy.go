@@ -5,14 +5,22 @@ import (
 
 	"github.com/containers/buildah"
 	buildahcli "github.com/containers/buildah/pkg/cli"
+	"github.com/containers/buildah/pkg/parse"
+	"github.com/containers/storage"
+	securejoin "github.com/cyphar/filepath-securejoin"
 	"github.com/opencontainers/go-digest"
 	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
 	"github.com/spf13/cobra"
 )
 
 type addCopyResults struct {
 	addHistory bool
+	checksum   string
+	chmod      string
 	chown      string
+	chownSrc   string
+	from       string
 	quiet      bool
 }
 
@@ -44,7 +52,8 @@ func init() {
 			return copyCmd(cmd, args, copyOpts)
 		},
 		Example: `buildah copy containerID '/myapp/app.conf'
-  buildah copy containerID '/myapp/app.conf' '/myapp/app.conf'`,
+  buildah copy containerID '/myapp/app.conf' '/myapp/app.conf'
+  buildah copy --from otherContainerID containerID '/myapp/app.conf' '/myapp/app.conf'`,
 		Args: cobra.MinimumNArgs(1),
 	}
 	copyCommand.SetUsageTemplate(UsageTemplate())
@@ -52,14 +61,20 @@ func init() {
 	addFlags := addCommand.Flags()
 	addFlags.SetInterspersed(false)
 	addFlags.BoolVar(&addOpts.addHistory, "add-history", false, "add an entry for this operation to the image's history.  Use BUILDAH_HISTORY environment variable to override. (default false)")
+	addFlags.StringVar(&addOpts.checksum, "checksum", "", "require that content downloaded from URLs have the specified `digest`")
+	addFlags.StringVar(&addOpts.chmod, "chmod", "", "set the file mode bits of the destination content")
 	addFlags.StringVar(&addOpts.chown, "chown", "", "set the user and group ownership of the destination content")
+	addFlags.StringVar(&addOpts.chownSrc, "chown-source", buildah.ChownSourceTarget, "look up user and group names for --chown in the container (`target`) or on the host (host)")
 	addFlags.BoolVarP(&addOpts.quiet, "quiet", "q", false, "don't output a digest of the newly-added/copied content")
 
 	// TODO We could avoid some duplication here if need-be; given it is small, leaving as is
 	copyFlags := copyCommand.Flags()
 	copyFlags.SetInterspersed(false)
 	copyFlags.BoolVar(&copyOpts.addHistory, "add-history", false, "add an entry for this operation to the image's history.  Use BUILDAH_HISTORY environment variable to override. (default false)")
+	copyFlags.StringVar(&copyOpts.chmod, "chmod", "", "set the file mode bits of the destination content")
 	copyFlags.StringVar(&copyOpts.chown, "chown", "", "set the user and group ownership of the destination content")
+	copyFlags.StringVar(&copyOpts.chownSrc, "chown-source", buildah.ChownSourceTarget, "look up user and group names for --chown in the container (`target`) or on the host (host)")
+	copyFlags.StringVar(&copyOpts.from, "from", "", "use the specified container or image as the source of the content")
 	copyFlags.BoolVarP(&copyOpts.quiet, "quiet", "q", false, "don't output a digest of the newly-added/copied content")
 
 	rootCmd.AddCommand(addCommand)
@@ -100,8 +115,34 @@ func addAndCopyCmd(c *cobra.Command, args []string, verb string, extractLocalArc
 
 	digester := digest.Canonical.Digester()
 	options := buildah.AddAndCopyOptions{
-		Chown:  iopts.chown,
-		Hasher: digester.Hash(),
+		Checksum:    iopts.checksum,
+		Chmod:       iopts.chmod,
+		Chown:       iopts.chown,
+		ChownSource: iopts.chownSrc,
+		Hasher:      digester.Hash(),
+	}
+
+	if iopts.from != "" {
+		source, cleanup, err := openSourceBuilder(c, store, iopts.from)
+		if err != nil {
+			return err
+		}
+		defer cleanup()
+		mountPoint, err := source.Mount(source.MountLabel)
+		if err != nil {
+			return errors.Wrapf(err, "error mounting %q", iopts.from)
+		}
+		defer func() {
+			if err := source.Unmount(); err != nil {
+				logrus.Errorf("error unmounting %q: %v", iopts.from, err)
+			}
+		}()
+		for i, src := range args {
+			if args[i], err = securejoin.SecureJoin(mountPoint, src); err != nil {
+				return errors.Wrapf(err, "error resolving %q in %q", src, iopts.from)
+			}
+		}
+		options.IDMappingOptions = &source.IDMappingOptions
 	}
 
 	if err := builder.Add(dest, extractLocalArchives, options, args...); err != nil {
@@ -115,6 +156,35 @@ func addAndCopyCmd(c *cobra.Command, args []string, verb string, extractLocalArc
 	return builder.Save()
 }
 
+// openSourceBuilder returns a working container that we can read content from
+// for "copy --from".  If name doesn't refer to a container, a temporary one is
+// created using the image with that name, and the returned cleanup function
+// removes it.
+func openSourceBuilder(c *cobra.Command, store storage.Store, name string) (*buildah.Builder, func(), error) {
+	if builder, err := openBuilder(getContext(), store, name); err == nil {
+		return builder, func() {}, nil
+	}
+	systemContext, err := parse.SystemContextFromOptions(c)
+	if err != nil {
+		return nil, nil, errors.Wrapf(err, "error building system context")
+	}
+	options := buildah.BuilderOptions{
+		FromImage:     name,
+		PullPolicy:    buildah.PullIfMissing,
+		SystemContext: systemContext,
+	}
+	builder, err := buildah.NewBuilder(getContext(), store, options)
+	if err != nil {
+		return nil, nil, errors.Wrapf(err, "no container or image found with name %q", name)
+	}
+	cleanup := func() {
+		if err := builder.Delete(); err != nil {
+			logrus.Errorf("error removing temporary container %q: %v", builder.Container, err)
+		}
+	}
+	return builder, cleanup, nil
+}
+
 func addCmd(c *cobra.Command, args []string, iopts addCopyResults) error {
 	return addAndCopyCmd(c, args, "ADD", true, iopts)
 }
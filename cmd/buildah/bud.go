@@ -1,6 +1,7 @@
 package main
 
 import (
+	"fmt"
 	"io/ioutil"
 	"os"
 	"path/filepath"
@@ -9,6 +10,7 @@ import (
 	"github.com/containers/buildah/imagebuildah"
 	buildahcli "github.com/containers/buildah/pkg/cli"
 	"github.com/containers/buildah/pkg/parse"
+	"github.com/mattn/go-shellwords"
 	"github.com/pkg/errors"
 	"github.com/sirupsen/logrus"
 	"github.com/spf13/cobra"
@@ -51,7 +53,8 @@ func init() {
 		},
 		Example: `buildah bud -f Dockerfile.simple .
   buildah bud --volume /home/test:/myvol:ro,Z -t imageName .
-  buildah bud -f Dockerfile.simple -f Dockerfile.notsosimple .`,
+  buildah bud -f Dockerfile.simple -f Dockerfile.notsosimple .
+  buildah bud -f build/Containerfile --context-dir .`,
 	}
 	budCommand.SetUsageTemplate(UsageTemplate())
 
@@ -123,11 +126,20 @@ func budCmd(c *cobra.Command, inputArgs []string, iopts budResults) error {
 	}
 	contextDir := ""
 	cliArgs := inputArgs
-	if len(cliArgs) == 0 {
-		return errors.Errorf("no context directory or URL specified")
+	contextArg := iopts.ContextDir
+	if contextArg != "" {
+		if len(cliArgs) > 0 {
+			return errors.Errorf("context directory specified both as an argument (%q) and using --context-dir (%q)", cliArgs[0], contextArg)
+		}
+	} else {
+		if len(cliArgs) == 0 {
+			return errors.Errorf("no context directory or URL specified")
+		}
+		contextArg = cliArgs[0]
+		cliArgs = Tail(cliArgs)
 	}
 	// The context directory could be a URL.  Try to handle that.
-	tempDir, subDir, err := imagebuildah.TempDirForURL("", "buildah", cliArgs[0])
+	tempDir, subDir, err := imagebuildah.TempDirForURL("", "buildah", contextArg)
 	if err != nil {
 		return errors.Wrapf(err, "error prepping temporary context directory")
 	}
@@ -142,13 +154,12 @@ func budCmd(c *cobra.Command, inputArgs []string, iopts budResults) error {
 		contextDir = filepath.Join(tempDir, subDir)
 	} else {
 		// Nope, it was local.  Use it as is.
-		absDir, err := filepath.Abs(cliArgs[0])
+		absDir, err := filepath.Abs(contextArg)
 		if err != nil {
-			return errors.Wrapf(err, "error determining path to directory %q", cliArgs[0])
+			return errors.Wrapf(err, "error determining path to directory %q", contextArg)
 		}
 		contextDir = absDir
 	}
-	cliArgs = Tail(cliArgs)
 
 	if err := buildahcli.VerifyFlagsArgsOrder(cliArgs); err != nil {
 		return err
@@ -156,8 +167,26 @@ func budCmd(c *cobra.Command, inputArgs []string, iopts budResults) error {
 	if len(dockerfiles) == 0 {
 		dockerfiles = append(dockerfiles, filepath.Join(contextDir, "Dockerfile"))
 	}
+	// Dockerfiles which were specified using relative paths are found
+	// relative to the current directory if they're there, and relative
+	// to the context directory if they aren't.  Either way, the contents
+	// of the context directory are all that COPY and ADD can see.
+	for i, dockerfile := range dockerfiles {
+		if strings.HasPrefix(dockerfile, "http://") || strings.HasPrefix(dockerfile, "https://") || filepath.IsAbs(dockerfile) {
+			continue
+		}
+		if _, err := os.Stat(dockerfile); err != nil {
+			dockerfiles[i] = filepath.Join(contextDir, dockerfile)
+			continue
+		}
+		absFile, err := filepath.Abs(dockerfile)
+		if err != nil {
+			return errors.Wrapf(err, "error determining path to %q", dockerfile)
+		}
+		dockerfiles[i] = absFile
+	}
 
-	var stdin, stdout, stderr, reporter *os.File
+	var stdin, stdout, stderr, reporter, logfile *os.File
 	stdin = os.Stdin
 	stdout = os.Stdout
 	stderr = os.Stderr
@@ -172,6 +201,7 @@ func budCmd(c *cobra.Command, inputArgs []string, iopts budResults) error {
 		stdout = f
 		stderr = f
 		reporter = f
+		logfile = f
 	}
 
 	store, err := getStore(c)
@@ -179,7 +209,7 @@ func budCmd(c *cobra.Command, inputArgs []string, iopts budResults) error {
 		return err
 	}
 
-	systemContext, err := parse.SystemContextFromOptions(c)
+	systemContext, destSystemContext, err := parse.SourceAndDestinationSystemContextsFromOptions(c)
 	if err != nil {
 		return errors.Wrapf(err, "error building system context")
 	}
@@ -245,50 +275,97 @@ func budCmd(c *cobra.Command, inputArgs []string, iopts budResults) error {
 		transientMounts = append(transientMounts, imagebuildah.Mount(mount))
 	}
 
+	labels := iopts.Label
+	if c.Flag("label-from-cmd").Changed {
+		cmdLabels, err := parse.LabelsFromCommands(iopts.LabelFromCmd)
+		if err != nil {
+			return err
+		}
+		labels = append(labels, cmdLabels...)
+	}
+
+	var defaultShell []string
+	if c.Flag("shell").Changed {
+		if defaultShell, err = shellwords.Parse(iopts.Shell); err != nil {
+			return errors.Wrapf(err, "error parsing --shell %q", iopts.Shell)
+		}
+		if len(defaultShell) == 0 {
+			return errors.Errorf("--shell requires a non-empty value")
+		}
+	}
+
 	options := imagebuildah.BuildOptions{
-		ContextDirectory:        contextDir,
-		PullPolicy:              pullPolicy,
-		Compression:             compression,
-		Quiet:                   iopts.Quiet,
-		SignaturePolicyPath:     iopts.SignaturePolicy,
-		Args:                    args,
-		Output:                  output,
-		AdditionalTags:          tags,
-		In:                      stdin,
-		Out:                     stdout,
-		Err:                     stderr,
-		ReportWriter:            reporter,
-		Runtime:                 iopts.Runtime,
-		RuntimeArgs:             runtimeFlags,
-		OutputFormat:            format,
-		SystemContext:           systemContext,
-		Isolation:               isolation,
-		NamespaceOptions:        namespaceOptions,
-		ConfigureNetwork:        networkPolicy,
-		CNIPluginPath:           iopts.CNIPlugInPath,
-		CNIConfigDir:            iopts.CNIConfigDir,
-		IDMappingOptions:        idmappingOptions,
-		AddCapabilities:         iopts.CapAdd,
-		DropCapabilities:        iopts.CapDrop,
-		CommonBuildOpts:         commonOpts,
-		DefaultMountsFilePath:   defaultsMountFile,
-		IIDFile:                 iopts.Iidfile,
-		Squash:                  iopts.Squash,
-		Labels:                  iopts.Label,
-		Annotations:             iopts.Annotation,
-		Layers:                  layers,
-		NoCache:                 iopts.NoCache,
-		RemoveIntermediateCtrs:  iopts.Rm,
-		ForceRmIntermediateCtrs: iopts.ForceRm,
-		BlobDirectory:           iopts.BlobCache,
-		Target:                  iopts.Target,
-		TransientMounts:         transientMounts,
+		ContextDirectory:         contextDir,
+		PullPolicy:               pullPolicy,
+		Compression:              compression,
+		Quiet:                    iopts.Quiet,
+		SignaturePolicyPath:      iopts.SignaturePolicy,
+		Args:                     args,
+		RequiredArgs:             iopts.RequireBuildArg,
+		Output:                   output,
+		AdditionalTags:           tags,
+		In:                       stdin,
+		Out:                      stdout,
+		Err:                      stderr,
+		ReportWriter:             reporter,
+		Runtime:                  iopts.Runtime,
+		RuntimeArgs:              runtimeFlags,
+		OutputFormat:             format,
+		SystemContext:            systemContext,
+		DestinationSystemContext: destSystemContext,
+		Isolation:                isolation,
+		NamespaceOptions:         namespaceOptions,
+		ConfigureNetwork:         networkPolicy,
+		CNIPluginPath:            iopts.CNIPlugInPath,
+		CNIConfigDir:             iopts.CNIConfigDir,
+		IDMappingOptions:         idmappingOptions,
+		AddCapabilities:          iopts.CapAdd,
+		DropCapabilities:         iopts.CapDrop,
+		CommonBuildOpts:          commonOpts,
+		DefaultMountsFilePath:    defaultsMountFile,
+		IIDFile:                  iopts.Iidfile,
+		Squash:                   iopts.Squash,
+		SquashTo:                 iopts.SquashTo,
+		Labels:                   labels,
+		Annotations:              iopts.Annotation,
+		Layers:                   layers,
+		NoCache:                  iopts.NoCache,
+		PrintCacheKeys:           iopts.PrintCacheKeys,
+		ChownSource:              iopts.ChownSource,
+		NoCacheStages:            iopts.NoCacheStage,
+		RemoveIntermediateCtrs:   iopts.Rm,
+		ForceRmIntermediateCtrs:  iopts.ForceRm,
+		BlobDirectory:            iopts.BlobCache,
+		Target:                   iopts.Target,
+		MaxParallelDownloads:     iopts.MaxParallelDownloads,
+		ProvenanceFile:           iopts.Provenance,
+		DefaultShell:             defaultShell,
+		EmbedContainerfile:       iopts.EmbedContainerfile,
+		TransientMounts:          transientMounts,
 	}
 
 	if iopts.Quiet {
 		options.ReportWriter = ioutil.Discard
 	}
 
-	_, _, err = imagebuildah.BuildDockerfiles(getContext(), store, options, dockerfiles...)
+	if logfile != nil {
+		// Everything is written to the log file, but the steps, and
+		// the ID of the image that we built, are also written to
+		// standard output, to provide a summary of the build there.
+		stepCounter := 0
+		options.Log = func(format string, args ...interface{}) {
+			stepCounter++
+			step := fmt.Sprintf("STEP %d: ", stepCounter) + fmt.Sprintf(format, args...) + "\n"
+			fmt.Fprint(logfile, step)
+			fmt.Fprint(os.Stdout, step)
+		}
+	}
+
+	ctx, stop := getInterruptibleContext()
+	defer stop()
+	id, _, err := imagebuildah.BuildDockerfiles(ctx, store, options, dockerfiles...)
+	if err == nil && logfile != nil && iopts.Iidfile == "" {
+		fmt.Printf("%s\n", id)
+	}
 	return err
 }
@@ -3,6 +3,7 @@ package main
 import (
 	"fmt"
 	"os"
+	"strings"
 	"time"
 
 	"github.com/containers/buildah"
@@ -26,12 +27,15 @@ type commitInputOptions struct {
 	disableCompression bool
 	format             string
 	iidfile            string
+	labelFromCmd       []string
 	omitTimestamp      bool
 	quiet              bool
+	recordConfig       bool
 	referenceTime      string
 	rm                 bool
 	signaturePolicy    string
 	squash             bool
+	squashTo           int
 	tlsVerify          bool
 }
 
@@ -67,8 +71,10 @@ func init() {
 	flags.BoolVarP(&opts.disableCompression, "disable-compression", "D", true, "don't compress layers")
 	flags.StringVarP(&opts.format, "format", "f", defaultFormat(), "`format` of the image manifest and metadata")
 	flags.StringVar(&opts.iidfile, "iidfile", "", "Write the image ID to the file")
+	flags.StringArrayVar(&opts.labelFromCmd, "label-from-cmd", []string{}, "set an image label to the output of a command (`name=command [args...]`)")
 	flags.BoolVar(&opts.omitTimestamp, "omit-timestamp", false, "set created timestamp to epoch 0 to allow for deterministic builds")
 	flags.BoolVarP(&opts.quiet, "quiet", "q", false, "don't output progress information when writing images")
+	flags.BoolVar(&opts.recordConfig, "record-config-changes", false, "add an entry to the image's history describing changes made to its configuration")
 	flags.StringVar(&opts.referenceTime, "reference-time", "", "set the timestamp on the image to match the named `file`")
 
 	if err := flags.MarkHidden("reference-time"); err != nil {
@@ -83,6 +89,7 @@ func init() {
 	}
 
 	flags.BoolVar(&opts.squash, "squash", false, "produce an image with only one layer")
+	flags.IntVar(&opts.squashTo, "squash-to", 0, "merge the layers added to the base image until no more than `number` remain")
 	flags.BoolVar(&opts.tlsVerify, "tls-verify", true, "Require HTTPS and verify certificates when accessing the registry")
 
 	rootCmd.AddCommand(commitCommand)
@@ -141,6 +148,17 @@ func commitCmd(c *cobra.Command, args []string, iopts commitInputOptions) error
 		return errors.Wrapf(err, "error building system context")
 	}
 
+	if c.Flag("label-from-cmd").Changed {
+		labels, err := parse.LabelsFromCommands(iopts.labelFromCmd)
+		if err != nil {
+			return err
+		}
+		for _, label := range labels {
+			nameAndValue := strings.SplitN(label, "=", 2)
+			builder.SetLabel(nameAndValue[0], nameAndValue[1])
+		}
+	}
+
 	if image != "" {
 		if dest, err = alltransports.ParseImageName(image); err != nil {
 			candidates, _, _, err := util.ResolveName(image, "", systemContext, store)
@@ -168,6 +186,11 @@ func commitCmd(c *cobra.Command, args []string, iopts commitInputOptions) error
 		Squash:                iopts.squash,
 		BlobDirectory:         iopts.blobCache,
 		OmitTimestamp:         iopts.omitTimestamp,
+		RecordConfigChanges:   iopts.recordConfig,
+	}
+	if iopts.squashTo > 0 {
+		options.SquashTo = iopts.squashTo
+		options.SquashBase = builder.TopLayer
 	}
 	if !iopts.quiet {
 		options.ReportWriter = os.Stderr
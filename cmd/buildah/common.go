@@ -4,6 +4,8 @@ import (
 	"context"
 	"fmt"
 	"os"
+	"os/signal"
+	"syscall"
 	"time"
 
 	"github.com/containers/buildah"
@@ -14,6 +16,7 @@ import (
 	"github.com/opencontainers/go-digest"
 	imgspecv1 "github.com/opencontainers/image-spec/specs-go/v1"
 	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
 	"github.com/spf13/cobra"
 	"github.com/spf13/pflag"
 )
@@ -191,6 +194,27 @@ func getContext() context.Context {
 	return context.TODO()
 }
 
+// getInterruptibleContext returns a context which is canceled if we receive
+// SIGHUP, SIGINT, or SIGTERM, giving callers a chance to clean up instead of
+// being killed outright, and a function which stops watching for signals.
+func getInterruptibleContext() (context.Context, func()) {
+	ctx, cancel := context.WithCancel(getContext())
+	interrupted := make(chan os.Signal, 1)
+	signal.Notify(interrupted, syscall.SIGHUP, syscall.SIGINT, syscall.SIGTERM)
+	go func() {
+		select {
+		case receivedSignal := <-interrupted:
+			logrus.Debugf("received %v, canceling", receivedSignal)
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+	return ctx, func() {
+		signal.Stop(interrupted)
+		cancel()
+	}
+}
+
 func getUserFlags() pflag.FlagSet {
 	fs := pflag.FlagSet{}
 	fs.String("user", "", "`user[:group]` to run the command as")
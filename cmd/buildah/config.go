@@ -139,7 +139,7 @@ func conditionallyAddHistory(builder *buildah.Builder, c *cobra.Command, created
 	}
 }
 
-func updateConfig(builder *buildah.Builder, c *cobra.Command, iopts configResults) {
+func updateConfig(builder *buildah.Builder, c *cobra.Command, iopts configResults) error {
 	if c.Flag("author").Changed {
 		builder.SetMaintainer(iopts.author)
 	}
@@ -167,11 +167,17 @@ func updateConfig(builder *buildah.Builder, c *cobra.Command, iopts configResult
 		conditionallyAddHistory(builder, c, "/bin/sh -c #(nop) SHELL %s", shell)
 	}
 	if c.Flag("stop-signal").Changed {
-		builder.SetStopSignal(iopts.stopSignal)
+		if err := builder.SetStopSignal(iopts.stopSignal); err != nil {
+			return err
+		}
 		conditionallyAddHistory(builder, c, "/bin/sh -c #(nop) STOPSIGNAL %s", iopts.stopSignal)
 	}
 	if c.Flag("port").Changed {
 		for _, portSpec := range iopts.ports {
+			if strings.HasSuffix(portSpec, "-") {
+				builder.RemovePort(strings.TrimSuffix(portSpec, "-"))
+				continue
+			}
 			builder.SetPort(portSpec)
 		}
 		conditionallyAddHistory(builder, c, "/bin/sh -c #(nop) EXPOSE %s", strings.Join(iopts.ports, " "))
@@ -272,6 +278,7 @@ func updateConfig(builder *buildah.Builder, c *cobra.Command, iopts configResult
 			}
 		}
 	}
+	return nil
 }
 
 func updateHealthcheck(builder *buildah.Builder, c *cobra.Command, iopts configResults) {
@@ -356,6 +363,8 @@ func configCmd(c *cobra.Command, args []string, iopts configResults) error {
 		return errors.Wrapf(err, "error reading build container %q", name)
 	}
 
-	updateConfig(builder, c, iopts)
+	if err := updateConfig(builder, c, iopts); err != nil {
+		return err
+	}
 	return builder.Save()
 }
@@ -140,7 +140,9 @@ func onBuild(builder *buildah.Builder) error {
 		case "SHELL":
 			builder.SetShell(args)
 		case "STOPSIGNAL":
-			builder.SetStopSignal(strings.Join(args, " "))
+			if err := builder.SetStopSignal(strings.Join(args, " ")); err != nil {
+				return err
+			}
 		case "USER":
 			builder.SetUser(strings.Join(args, " "))
 		case "VOLUME":
@@ -229,6 +231,7 @@ func fromCmd(c *cobra.Command, args []string, iopts fromReply) error {
 		CommonBuildOpts:       commonOpts,
 		Format:                format,
 		BlobDirectory:         iopts.BlobCache,
+		MaxParallelDownloads:  iopts.MaxParallelDownloads,
 	}
 
 	if !iopts.quiet {
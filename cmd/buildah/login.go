@@ -60,6 +60,9 @@ func loginCmd(c *cobra.Command, args []string, iopts *loginReply) error {
 	if len(args) == 0 {
 		return errors.Errorf("please specify a registry to login to")
 	}
+	if strings.HasPrefix(iopts.authfile, parse.AuthFileFromEnvPrefix) {
+		return errors.Errorf("credentials can not be saved to an environment variable, please specify an authentication file using --authfile")
+	}
 	server := parse.RegistryFromFullName(parse.ScrubServer(args[0]))
 	systemContext, err := parse.SystemContextFromOptions(c)
 	if err != nil {
@@ -2,6 +2,7 @@ package main
 
 import (
 	"fmt"
+	"strings"
 
 	buildahcli "github.com/containers/buildah/pkg/cli"
 	"github.com/containers/buildah/pkg/parse"
@@ -45,6 +46,9 @@ func logoutCmd(c *cobra.Command, args []string, iopts *logoutReply) error {
 	if len(args) == 0 && !iopts.all {
 		return errors.Errorf("registry must be given")
 	}
+	if strings.HasPrefix(iopts.authfile, parse.AuthFileFromEnvPrefix) {
+		return errors.Errorf("credentials can not be removed from an environment variable, please specify an authentication file using --authfile")
+	}
 	var server string
 	if len(args) == 1 {
 		server = parse.ScrubServer(args[0])
@@ -12,14 +12,15 @@ import (
 )
 
 type pullResults struct {
-	allTags         bool
-	authfile        string
-	blobCache       string
-	certDir         string
-	creds           string
-	signaturePolicy string
-	quiet           bool
-	tlsVerify       bool
+	allTags              bool
+	authfile             string
+	blobCache            string
+	certDir              string
+	creds                string
+	maxParallelDownloads int
+	signaturePolicy      string
+	quiet                bool
+	tlsVerify            bool
 }
 
 func init() {
@@ -51,6 +52,7 @@ func init() {
 	flags.StringVar(&opts.blobCache, "blob-cache", "", "store copies of pulled image blobs in the specified directory")
 	flags.StringVar(&opts.certDir, "cert-dir", "", "use certificates at the specified path to access the registry")
 	flags.StringVar(&opts.creds, "creds", "", "use `[username[:password]]` for accessing the registry")
+	flags.IntVar(&opts.maxParallelDownloads, "max-parallel-downloads", 0, "maximum number of layers to download at the same time (default is the image library's built-in limit)")
 	flags.StringVar(&opts.signaturePolicy, "signature-policy", "", "`pathname` of signature policy file (not usually used)")
 	if err := flags.MarkHidden("signature-policy"); err != nil {
 		panic(fmt.Sprintf("error marking signature-policy as hidden: %v", err))
@@ -86,12 +88,13 @@ func pullCmd(c *cobra.Command, args []string, iopts pullResults) error {
 	}
 
 	options := buildah.PullOptions{
-		SignaturePolicyPath: iopts.signaturePolicy,
-		Store:               store,
-		SystemContext:       systemContext,
-		BlobDirectory:       iopts.blobCache,
-		AllTags:             iopts.allTags,
-		ReportWriter:        os.Stderr,
+		SignaturePolicyPath:  iopts.signaturePolicy,
+		Store:                store,
+		SystemContext:        systemContext,
+		BlobDirectory:        iopts.blobCache,
+		AllTags:              iopts.allTags,
+		MaxParallelDownloads: iopts.maxParallelDownloads,
+		ReportWriter:         os.Stderr,
 	}
 
 	if iopts.quiet {
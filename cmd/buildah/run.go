@@ -20,6 +20,8 @@ type runInputOptions struct {
 	addHistory     bool
 	capAdd         []string
 	capDrop        []string
+	cpuRtPeriod    uint64
+	cpuRtRuntime   int64
 	hostname       string
 	isolation      string
 	runtime        string
@@ -60,6 +62,8 @@ func init() {
 	flags.BoolVar(&opts.addHistory, "add-history", false, "add an entry for this operation to the image's history.  Use BUILDAH_HISTORY environment variable to override. (default false)")
 	flags.StringSliceVar(&opts.capAdd, "cap-add", []string{}, "add the specified capability (default []")
 	flags.StringSliceVar(&opts.capDrop, "cap-drop", []string{}, "drop the specified capability (default [])")
+	flags.Uint64Var(&opts.cpuRtPeriod, "cpu-rt-period", 0, "limit the CPU real-time period in microseconds")
+	flags.Int64Var(&opts.cpuRtRuntime, "cpu-rt-runtime", 0, "limit the CPU real-time runtime in microseconds")
 	flags.StringVar(&opts.hostname, "hostname", "", "set the hostname inside of the container")
 	flags.StringVar(&opts.isolation, "isolation", buildahcli.DefaultIsolation(), "`type` of process isolation to use. Use BUILDAH_ISOLATION environment variable to override.")
 	flags.StringVar(&opts.runtime, "runtime", util.Runtime(), "`path` to an alternate OCI runtime")
@@ -126,18 +130,20 @@ func runCmd(c *cobra.Command, args []string, iopts runInputOptions) error {
 	}
 
 	options := buildah.RunOptions{
-		Hostname:         iopts.hostname,
-		Runtime:          iopts.runtime,
-		Args:             runtimeFlags,
-		NoPivot:          noPivot,
-		User:             c.Flag("user").Value.String(),
-		Isolation:        isolation,
-		NamespaceOptions: namespaceOptions,
-		ConfigureNetwork: networkPolicy,
-		CNIPluginPath:    iopts.CNIPlugInPath,
-		CNIConfigDir:     iopts.CNIConfigDir,
-		AddCapabilities:  iopts.capAdd,
-		DropCapabilities: iopts.capDrop,
+		Hostname:           iopts.hostname,
+		Runtime:            iopts.runtime,
+		Args:               runtimeFlags,
+		NoPivot:            noPivot,
+		User:               c.Flag("user").Value.String(),
+		Isolation:          isolation,
+		NamespaceOptions:   namespaceOptions,
+		ConfigureNetwork:   networkPolicy,
+		CNIPluginPath:      iopts.CNIPlugInPath,
+		CNIConfigDir:       iopts.CNIConfigDir,
+		AddCapabilities:    iopts.capAdd,
+		DropCapabilities:   iopts.capDrop,
+		CPURealtimePeriod:  iopts.cpuRtPeriod,
+		CPURealtimeRuntime: iopts.cpuRtRuntime,
 	}
 
 	if c.Flag("terminal").Changed {
@@ -154,7 +160,12 @@ func runCmd(c *cobra.Command, args []string, iopts runInputOptions) error {
 	}
 	options.Mounts = mounts
 
+	ctx, stop := getInterruptibleContext()
+	defer stop()
 	runerr := builder.Run(args, options)
+	if runerr == nil && ctx.Err() != nil {
+		runerr = errors.Wrapf(ctx.Err(), "error running %v in container %q", args, builder.Container)
+	}
 	if runerr != nil {
 		logrus.Debugf("error running %v in container %q: %v", args, builder.Container, runerr)
 	}
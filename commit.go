@@ -5,6 +5,7 @@ import (
 	"fmt"
 	"io"
 	"io/ioutil"
+	"os"
 	"strings"
 	"time"
 
@@ -21,6 +22,7 @@ import (
 	"github.com/containers/storage/pkg/archive"
 	"github.com/containers/storage/pkg/stringid"
 	digest "github.com/opencontainers/go-digest"
+	v1 "github.com/opencontainers/image-spec/specs-go/v1"
 	"github.com/pkg/errors"
 	"github.com/sirupsen/logrus"
 )
@@ -34,6 +36,16 @@ type CommitOptions struct {
 	// layer blobs.  The default is to not use compression, but
 	// archive.Gzip is recommended.
 	Compression archive.Compression
+	// LayerCompression overrides Compression for individual layer blobs.
+	// The Nth entry applies to the Nth layer of the new image, counting
+	// from the first layer of its base image.  Layers for which no entry
+	// is present use Compression.  Use archive.Uncompressed to store a
+	// layer which contains data that is already compressed as-is, and
+	// archive.Gzip to compress others.  Layers which are reused from the
+	// base image without being re-exported are always stored as-is.
+	// Destinations which insist on compressed layers, such as registries,
+	// may still compress layers which are stored uncompressed here.
+	LayerCompression []archive.Compression
 	// SignaturePolicyPath specifies an override location for the signature
 	// policy which should be used for verifying the new image as it is
 	// being written.  Except in specific circumstances, no value should be
@@ -58,6 +70,16 @@ type CommitOptions struct {
 	// Squash tells the builder to produce an image with a single layer
 	// instead of with possibly more than one layer.
 	Squash bool
+	// SquashTo, if greater than zero, tells the builder to merge adjacent
+	// layers above SquashBase, smallest combined size first, until no
+	// more than SquashTo of them remain.  It can not be used along with
+	// Squash.
+	SquashTo int
+	// SquashBase is the ID of the topmost layer which is left alone when
+	// SquashTo is set, usually the top layer of the image that the working
+	// container was created from.  If not set, any of the layers may be
+	// merged.
+	SquashBase string
 	// BlobDirectory is the name of a directory in which we'll look for
 	// prebuilt copies of layer blobs that we might otherwise need to
 	// regenerate from on-disk layers.  If blobs are available, the
@@ -70,6 +92,20 @@ type CommitOptions struct {
 	// OmitTimestamp forces epoch 0 as created timestamp to allow for
 	// deterministic, content-addressable builds.
 	OmitTimestamp bool
+	// RecordConfigChanges adds an entry to the image's history which
+	// describes how its configuration differs from that of the image
+	// that the container was based on, for example which labels were
+	// added, changed, or removed.  No entry is added if the configuration
+	// is unchanged.
+	RecordConfigChanges bool
+	// Progress, if set, is called with reports of how much of each blob
+	// has been written, no more often than once per ProgressInterval,
+	// or DefaultProgressInterval if that is not set.  The report for a
+	// blob's final bytes may be skipped.
+	Progress ProgressFunc
+	// ProgressInterval is the minimum amount of time between calls to
+	// Progress for a single blob.
+	ProgressInterval time.Duration
 }
 
 // PushOptions can be used to alter how an image is copied somewhere.
@@ -104,6 +140,14 @@ type PushOptions struct {
 	// the user will be displayed, this is best used for logging.
 	// The default is false.
 	Quiet bool
+	// Progress, if set, is called with reports of how much of each blob
+	// has been written, no more often than once per ProgressInterval,
+	// or DefaultProgressInterval if that is not set.  The report for a
+	// blob's final bytes may be skipped.
+	Progress ProgressFunc
+	// ProgressInterval is the minimum amount of time between calls to
+	// Progress for a single blob.
+	ProgressInterval time.Duration
 }
 
 var (
@@ -129,6 +173,13 @@ var (
 func (b *Builder) Commit(ctx context.Context, dest types.ImageReference, options CommitOptions) (string, reference.Canonical, digest.Digest, error) {
 	var imgID string
 
+	if options.SquashTo < 0 {
+		return imgID, nil, "", errors.Errorf("invalid number of layers to squash to: %d", options.SquashTo)
+	}
+	if options.Squash && options.SquashTo > 0 {
+		return imgID, nil, "", errors.Errorf("squashing to a single layer and squashing to a number of layers are mutually exclusive")
+	}
+
 	// If we weren't given a name, build a destination reference using a
 	// temporary name that we'll remove later.  The correct thing to do
 	// would be to read the manifest and configuration blob, and ask the
@@ -177,18 +228,40 @@ func (b *Builder) Commit(ctx context.Context, dest types.ImageReference, options
 			}
 		}
 	}
+	// If we've been asked to, describe the changes that were made to the
+	// configuration, so that we can note them in the history.
+	configChanges := ""
+	if options.RecordConfigChanges {
+		base := &v1.ImageConfig{}
+		if b.FromImageID != "" {
+			baseImage, err := b.imageOCIConfig(ctx, systemContext, b.FromImageID)
+			if err != nil {
+				return imgID, nil, "", errors.Wrapf(err, "error reading configuration of base image %q", b.FromImageID)
+			}
+			base = &baseImage.Config
+		}
+		configChanges = describeConfigChanges(base, &b.OCIv1.Config)
+	}
 	// Build an image reference from which we can copy the finished image.
-	src, err := b.makeImageRef(options, exportBaseLayers)
+	src, err := b.makeImageRef(options, exportBaseLayers, configChanges)
 	if err != nil {
 		return imgID, nil, "", errors.Wrapf(err, "error computing layer digests and building metadata for container %q", b.ContainerID)
 	}
 	// In case we're using caching, decide how to handle compression for a cache.
 	// If we're using blob caching, set it up for the source.
+	// If any layers are meant to be left uncompressed, make sure that
+	// nothing between here and the destination compresses them for us.
+	storeSomeLayers := false
+	for _, compression := range options.LayerCompression {
+		if compression == archive.Uncompressed {
+			storeSomeLayers = true
+		}
+	}
 	var maybeCachedSrc = types.ImageReference(src)
 	var maybeCachedDest = types.ImageReference(dest)
 	if options.BlobDirectory != "" {
 		compress := types.PreserveOriginal
-		if options.Compression != archive.Uncompressed {
+		if options.Compression != archive.Uncompressed && !storeSomeLayers {
 			compress = types.Compress
 		}
 		cache, err := blobcache.NewBlobCache(src, options.BlobDirectory, compress)
@@ -207,10 +280,17 @@ func (b *Builder) Commit(ctx context.Context, dest types.ImageReference, options
 	case archive.Uncompressed:
 		systemContext.OCIAcceptUncompressedLayers = true
 	case archive.Gzip:
-		systemContext.DirForceCompress = true
+		systemContext.DirForceCompress = !storeSomeLayers
+	}
+	if storeSomeLayers {
+		systemContext.OCIAcceptUncompressedLayers = true
 	}
 	var manifestBytes []byte
-	if manifestBytes, err = cp.Image(ctx, policyContext, maybeCachedDest, maybeCachedSrc, getCopyOptions(b.store, options.ReportWriter, maybeCachedSrc, nil, maybeCachedDest, systemContext, "")); err != nil {
+	copyOptions := getCopyOptions(b.store, options.ReportWriter, maybeCachedSrc, nil, maybeCachedDest, systemContext, "")
+	stopProgress := addProgress(copyOptions, options.Progress, options.ProgressInterval)
+	manifestBytes, err = cp.Image(ctx, policyContext, maybeCachedDest, maybeCachedSrc, copyOptions)
+	stopProgress()
+	if err != nil {
 		return imgID, nil, "", errors.Wrapf(err, "error copying layers and metadata for container %q", b.ContainerID)
 	}
 	// If we've got more names to attach, and we know how to do that for
@@ -321,7 +401,11 @@ func Push(ctx context.Context, image string, dest types.ImageReference, options
 		systemContext.DirForceCompress = true
 	}
 	var manifestBytes []byte
-	if manifestBytes, err = cp.Image(ctx, policyContext, dest, maybeCachedSrc, getCopyOptions(options.Store, options.ReportWriter, maybeCachedSrc, nil, dest, systemContext, options.ManifestType)); err != nil {
+	copyOptions := getCopyOptions(options.Store, options.ReportWriter, maybeCachedSrc, nil, dest, systemContext, options.ManifestType)
+	stopProgress := addProgress(copyOptions, options.Progress, options.ProgressInterval)
+	manifestBytes, err = cp.Image(ctx, policyContext, dest, maybeCachedSrc, copyOptions)
+	stopProgress()
+	if err != nil {
 		return nil, "", errors.Wrapf(err, "error copying layers and metadata from %q to %q", transports.ImageName(maybeCachedSrc), transports.ImageName(dest))
 	}
 	if options.ReportWriter != nil {
@@ -340,3 +424,68 @@ func Push(ctx context.Context, image string, dest types.ImageReference, options
 	}
 	return ref, manifestDigest, nil
 }
+
+// DestinationResult describes the outcome of copying a committed image to one
+// of the destinations passed to CommitToMultipleDestinations.
+type DestinationResult struct {
+	// Destination is the reference which the image was copied to.
+	Destination types.ImageReference
+	// Ref is the canonical reference of the copied image, if the
+	// destination has a name which could be used to build one.
+	Ref reference.Canonical
+	// Digest is the digest of the manifest which was written to the
+	// destination.
+	Digest digest.Digest
+	// Err is the error, if any, which prevented the image from being
+	// written to the destination.
+	Err error
+}
+
+// CommitToMultipleDestinations commits the contents of the container to a new
+// image in local storage, and then copies that image to each of the specified
+// destinations.  Layer blobs are compressed at most once, and the compressed
+// copies are reused for every destination, using options.BlobDirectory if one
+// was specified and a temporary directory otherwise.  Returns the ID of the new
+// image and a result for each of the destinations, in the order in which they
+// were specified.  A failure to write to one destination does not prevent
+// attempts to write to the others.
+func (b *Builder) CommitToMultipleDestinations(ctx context.Context, dests []types.ImageReference, options CommitOptions) (string, []DestinationResult, error) {
+	blobDirectory := options.BlobDirectory
+	if blobDirectory == "" {
+		tmpdir, err := ioutil.TempDir("", Package)
+		if err != nil {
+			return "", nil, errors.Wrapf(err, "error creating temporary blob cache directory")
+		}
+		defer func() {
+			if err2 := os.RemoveAll(tmpdir); err2 != nil {
+				logrus.Debugf("error removing temporary blob cache directory %q: %v", tmpdir, err2)
+			}
+		}()
+		blobDirectory = tmpdir
+	}
+	options.BlobDirectory = blobDirectory
+
+	imgID, _, _, err := b.Commit(ctx, nil, options)
+	if err != nil {
+		return "", nil, err
+	}
+
+	pushOptions := PushOptions{
+		Compression:         options.Compression,
+		SignaturePolicyPath: options.SignaturePolicyPath,
+		ReportWriter:        options.ReportWriter,
+		Store:               b.store,
+		SystemContext:       options.SystemContext,
+		BlobDirectory:       blobDirectory,
+	}
+	results := make([]DestinationResult, 0, len(dests))
+	for _, dest := range dests {
+		result := DestinationResult{Destination: dest}
+		result.Ref, result.Digest, result.Err = Push(ctx, imgID, dest, pushOptions)
+		if result.Err != nil {
+			logrus.Debugf("error copying image %q to %q: %v", imgID, transports.ImageName(dest), result.Err)
+		}
+		results = append(results, result)
+	}
+	return imgID, results, nil
+}
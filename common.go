@@ -4,11 +4,13 @@ import (
 	"io"
 	"os"
 	"path/filepath"
+	"time"
 
 	"github.com/containers/buildah/pkg/unshare"
 	cp "github.com/containers/image/copy"
 	"github.com/containers/image/types"
 	"github.com/containers/storage"
+	digest "github.com/opencontainers/go-digest"
 )
 
 const (
@@ -16,8 +18,61 @@ const (
 	OCI = "oci"
 	// DOCKER used to define the "docker" image format
 	DOCKER = "docker"
+	// DefaultProgressInterval is the minimum amount of time between
+	// reports of progress for a single blob, if a caller asks for progress
+	// reports without specifying an interval.
+	DefaultProgressInterval = time.Second
 )
 
+// BlobProgress describes how much of a blob has been copied while pulling,
+// committing, or pushing an image.
+type BlobProgress struct {
+	// Digest is the digest of the blob, if it is known.
+	Digest digest.Digest
+	// Size is the size of the blob, or -1 if it is not known.
+	Size int64
+	// Offset is the number of bytes of the blob which have been copied
+	// so far.
+	Offset uint64
+}
+
+// ProgressFunc is called with reports of progress while blobs are being
+// copied.  It is called from a goroutine other than the one which started the
+// copy, but never from more than one goroutine at a time, and the copy waits
+// for it to return.
+type ProgressFunc func(BlobProgress)
+
+// addProgress arranges for progress which is reported while copying an image
+// using options to be passed to progress, no more often than once per interval
+// for each blob.  The returned function must be called after the copy has
+// finished.
+func addProgress(options *cp.Options, progress ProgressFunc, interval time.Duration) func() {
+	if progress == nil {
+		return func() {}
+	}
+	if interval <= 0 {
+		interval = DefaultProgressInterval
+	}
+	channel := make(chan types.ProgressProperties)
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for properties := range channel {
+			progress(BlobProgress{
+				Digest: properties.Artifact.Digest,
+				Size:   properties.Artifact.Size,
+				Offset: properties.Offset,
+			})
+		}
+	}()
+	options.Progress = channel
+	options.ProgressInterval = interval
+	return func() {
+		close(channel)
+		<-done
+	}
+}
+
 func getCopyOptions(store storage.Store, reportWriter io.Writer, sourceReference types.ImageReference, sourceSystemContext *types.SystemContext, destinationReference types.ImageReference, destinationSystemContext *types.SystemContext, manifestType string) *cp.Options {
 	sourceCtx := getSystemContext(store, nil, "")
 	if sourceSystemContext != nil {
@@ -0,0 +1,42 @@
+package buildah
+
+import (
+	"testing"
+
+	cp "github.com/containers/image/copy"
+	"github.com/containers/image/types"
+	digest "github.com/opencontainers/go-digest"
+)
+
+func TestAddProgress(t *testing.T) {
+	options := &cp.Options{}
+	stop := addProgress(options, nil, 0)
+	stop()
+	if options.Progress != nil {
+		t.Fatalf("expected no progress channel without a callback")
+	}
+
+	var reports []BlobProgress
+	stop = addProgress(options, func(p BlobProgress) {
+		reports = append(reports, p)
+	}, 0)
+	if options.Progress == nil || options.ProgressInterval != DefaultProgressInterval {
+		t.Fatalf("expected a progress channel with the default interval, got %v, %v", options.Progress, options.ProgressInterval)
+	}
+	blob := types.BlobInfo{Digest: digest.FromString("blob"), Size: 100}
+	options.Progress <- types.ProgressProperties{Artifact: blob, Offset: 10}
+	options.Progress <- types.ProgressProperties{Artifact: blob, Offset: 60}
+	stop()
+	expected := []BlobProgress{
+		{Digest: blob.Digest, Size: 100, Offset: 10},
+		{Digest: blob.Digest, Size: 100, Offset: 60},
+	}
+	if len(reports) != len(expected) {
+		t.Fatalf("expected %d reports, got %d: %v", len(expected), len(reports), reports)
+	}
+	for i := range expected {
+		if reports[i] != expected[i] {
+			t.Errorf("report %d: expected %+v, got %+v", i, expected[i], reports[i])
+		}
+	}
+}
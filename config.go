@@ -3,11 +3,15 @@ package buildah
 import (
 	"context"
 	"encoding/json"
+	"fmt"
+	"reflect"
 	"runtime"
+	"sort"
 	"strings"
 	"time"
 
 	"github.com/containers/buildah/docker"
+	"github.com/containers/buildah/util"
 	"github.com/containers/image/manifest"
 	"github.com/containers/image/transports"
 	"github.com/containers/image/types"
@@ -197,12 +201,45 @@ func (b *Builder) User() string {
 // SetUser sets information about the user as whom the container, or a
 // container built using an image built from this container, should be run.
 // Acceptable forms are a user name or ID, optionally followed by a colon and a
-// group name or ID.
+// group name or ID.  The value is stored verbatim, and is only resolved when
+// a command is run.
 func (b *Builder) SetUser(spec string) {
 	b.OCIv1.Config.User = spec
 	b.Docker.Config.User = spec
 }
 
+// SetUserFromImage sets the user as whom the container, or a container built
+// using an image built from this container, should be run, to the value which
+// is set in the configuration of the specified image.  The image must already
+// be present in local storage.  The value is copied verbatim, so a user which
+// was specified by name is not resolved to a numeric ID, and vice versa.
+func (b *Builder) SetUserFromImage(ctx context.Context, systemContext *types.SystemContext, image string) error {
+	config, err := b.imageOCIConfig(ctx, systemContext, image)
+	if err != nil {
+		return err
+	}
+	b.SetUser(config.Config.User)
+	return nil
+}
+
+// imageOCIConfig reads the OCI configuration of an image in local storage.
+func (b *Builder) imageOCIConfig(ctx context.Context, systemContext *types.SystemContext, image string) (*ociv1.Image, error) {
+	ref, _, err := util.FindImage(b.store, "", systemContext, image)
+	if err != nil {
+		return nil, err
+	}
+	img, err := ref.NewImage(ctx, systemContext)
+	if err != nil {
+		return nil, errors.Wrapf(err, "error opening image %q", transports.ImageName(ref))
+	}
+	defer img.Close()
+	config, err := img.OCIConfig(ctx)
+	if err != nil {
+		return nil, errors.Wrapf(err, "error reading configuration of image %q", transports.ImageName(ref))
+	}
+	return config, nil
+}
+
 // OnBuild returns the OnBuild value from the container.
 func (b *Builder) OnBuild() []string {
 	return copyStringSlice(b.Docker.Config.OnBuild)
@@ -401,6 +438,38 @@ func (b *Builder) UnsetPort(p string) {
 	delete(b.Docker.Config.ExposedPorts, docker.Port(p))
 }
 
+// RemovePort removes an exposed port from the set of ports which should be
+// exposed when a container based on an image built from this container is run.
+// A port which is specified without a protocol is treated as a TCP port, so
+// "80" matches "80/tcp" and vice versa.  It returns true if a port was removed.
+func (b *Builder) RemovePort(p string) bool {
+	removed := false
+	port := normalizePort(p)
+	for k := range b.OCIv1.Config.ExposedPorts {
+		if normalizePort(k) == port {
+			delete(b.OCIv1.Config.ExposedPorts, k)
+			removed = true
+		}
+	}
+	for k := range b.Docker.Config.ExposedPorts {
+		if normalizePort(string(k)) == port {
+			delete(b.Docker.Config.ExposedPorts, k)
+			removed = true
+		}
+	}
+	return removed
+}
+
+// normalizePort adds the default "tcp" protocol to a port specification if it
+// doesn't already include a protocol, and lowercases the protocol.
+func normalizePort(p string) string {
+	spec := strings.SplitN(p, "/", 2)
+	if len(spec) == 1 || spec[1] == "" {
+		return spec[0] + "/tcp"
+	}
+	return spec[0] + "/" + strings.ToLower(spec[1])
+}
+
 // ClearPorts empties the set of ports which should be exposed when a container
 // based on an image built from this container is run.
 func (b *Builder) ClearPorts() {
@@ -524,10 +593,27 @@ func (b *Builder) StopSignal() string {
 }
 
 // SetStopSignal sets the signal which will be set in the container and in
-// containers built using images built from the container.
-func (b *Builder) SetStopSignal(stopSignal string) {
-	b.OCIv1.Config.StopSignal = stopSignal
-	b.Docker.Config.StopSignal = stopSignal
+// containers built using images built from the container.  The signal can be
+// specified using either its name or its number, and is stored using its
+// name, e.g. "SIGTERM".  An error is returned if the signal is not known.
+func (b *Builder) SetStopSignal(stopSignal string) error {
+	signal, err := util.NormalizeSignal(stopSignal)
+	if err != nil {
+		return errors.Wrapf(err, "error setting stop signal")
+	}
+	b.OCIv1.Config.StopSignal = signal
+	b.Docker.Config.StopSignal = signal
+	return nil
+}
+
+// SetStopSignalFromImage sets the stop signal to the one which is set in the
+// configuration of the specified image.
+func (b *Builder) SetStopSignalFromImage(ctx context.Context, systemContext *types.SystemContext, image string) error {
+	config, err := b.imageOCIConfig(ctx, systemContext, image)
+	if err != nil {
+		return err
+	}
+	return b.SetStopSignal(config.Config.StopSignal)
 }
 
 // Healthcheck returns information that recommends how a container engine
@@ -610,3 +696,106 @@ func (b *Builder) AddAppendedEmptyLayer(created *time.Time, createdBy, author, c
 func (b *Builder) ClearAppendedEmptyLayers() {
 	b.AppendedEmptyLayers = nil
 }
+
+// describeConfigChanges returns a summary of the differences between two image
+// configurations, suitable for use as a comment in an image's history, or an
+// empty string if there aren't any.
+func describeConfigChanges(before, after *ociv1.ImageConfig) string {
+	var changes []string
+	describeString := func(what, was, now string) {
+		if was == now {
+			return
+		}
+		if now == "" {
+			changes = append(changes, fmt.Sprintf("%s %q removed", what, was))
+			return
+		}
+		changes = append(changes, fmt.Sprintf("%s set to %q", what, now))
+	}
+	describeList := func(what string, was, now []string) {
+		if reflect.DeepEqual(was, now) || (len(was) == 0 && len(now) == 0) {
+			return
+		}
+		if len(now) == 0 {
+			changes = append(changes, fmt.Sprintf("%s removed", what))
+			return
+		}
+		changes = append(changes, fmt.Sprintf("%s set to %q", what, now))
+	}
+	describeMap := func(what string, was, now map[string]string) {
+		keys := make([]string, 0, len(was)+len(now))
+		for k := range was {
+			keys = append(keys, k)
+		}
+		for k := range now {
+			if _, ok := was[k]; !ok {
+				keys = append(keys, k)
+			}
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			oldValue, inOld := was[k]
+			newValue, inNew := now[k]
+			switch {
+			case !inNew:
+				changes = append(changes, fmt.Sprintf("%s %q removed", what, k))
+			case !inOld:
+				changes = append(changes, fmt.Sprintf("%s %q added with value %q", what, k, newValue))
+			case oldValue != newValue:
+				changes = append(changes, fmt.Sprintf("%s %q changed to %q", what, k, newValue))
+			}
+		}
+	}
+	describeSet := func(what string, was, now map[string]struct{}) {
+		oldMap := make(map[string]string, len(was))
+		for k := range was {
+			oldMap[k] = ""
+		}
+		newMap := make(map[string]string, len(now))
+		for k := range now {
+			newMap[k] = ""
+		}
+		keys := make([]string, 0, len(was)+len(now))
+		for k := range oldMap {
+			if _, ok := newMap[k]; !ok {
+				keys = append(keys, k)
+			}
+		}
+		for k := range newMap {
+			if _, ok := oldMap[k]; !ok {
+				keys = append(keys, k)
+			}
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			if _, ok := newMap[k]; ok {
+				changes = append(changes, fmt.Sprintf("%s %q added", what, k))
+			} else {
+				changes = append(changes, fmt.Sprintf("%s %q removed", what, k))
+			}
+		}
+	}
+	envMap := func(env []string) map[string]string {
+		m := make(map[string]string, len(env))
+		for _, e := range env {
+			kv := strings.SplitN(e, "=", 2)
+			if len(kv) > 1 {
+				m[kv[0]] = kv[1]
+			} else {
+				m[kv[0]] = ""
+			}
+		}
+		return m
+	}
+
+	describeString("user", before.User, after.User)
+	describeString("working directory", before.WorkingDir, after.WorkingDir)
+	describeString("stop signal", before.StopSignal, after.StopSignal)
+	describeList("entrypoint", before.Entrypoint, after.Entrypoint)
+	describeList("command", before.Cmd, after.Cmd)
+	describeMap("environment variable", envMap(before.Env), envMap(after.Env))
+	describeMap("label", before.Labels, after.Labels)
+	describeSet("port", before.ExposedPorts, after.ExposedPorts)
+	describeSet("volume", before.Volumes, after.Volumes)
+	return strings.Join(changes, "; ")
+}
@@ -0,0 +1,110 @@
+package buildah
+
+import (
+	"testing"
+
+	"github.com/containers/buildah/docker"
+	v1 "github.com/opencontainers/image-spec/specs-go/v1"
+)
+
+func TestRemovePort(t *testing.T) {
+	b := &Builder{
+		OCIv1:  v1.Image{},
+		Docker: docker.V2Image{},
+	}
+	b.Docker.Config = &docker.Config{}
+	for _, port := range []string{"80", "53/udp", "443/tcp"} {
+		b.SetPort(port)
+	}
+	for _, tc := range []struct {
+		port    string
+		removed bool
+	}{
+		{"80/tcp", true},
+		{"80", false},
+		{"53", false},
+		{"443", true},
+		{"53/UDP", true},
+	} {
+		if removed := b.RemovePort(tc.port); removed != tc.removed {
+			t.Errorf("RemovePort(%q) returned %v, expected %v", tc.port, removed, tc.removed)
+		}
+	}
+	if len(b.OCIv1.Config.ExposedPorts) != 0 || len(b.Docker.Config.ExposedPorts) != 0 {
+		t.Errorf("expected no exposed ports, have %v and %v", b.OCIv1.Config.ExposedPorts, b.Docker.Config.ExposedPorts)
+	}
+}
+
+func TestDescribeConfigChanges(t *testing.T) {
+	before := v1.ImageConfig{
+		User:         "root",
+		Env:          []string{"PATH=/bin", "REMOVED=1"},
+		Labels:       map[string]string{"kept": "1", "changed": "old", "removed": "x"},
+		ExposedPorts: map[string]struct{}{"80/tcp": {}},
+		Cmd:          []string{"/bin/sh"},
+	}
+	after := v1.ImageConfig{
+		User:         "root",
+		Env:          []string{"PATH=/usr/bin:/bin", "ADDED=2"},
+		Labels:       map[string]string{"kept": "1", "changed": "new", "added": "y"},
+		ExposedPorts: map[string]struct{}{"443/tcp": {}},
+		Cmd:          []string{"/bin/sh"},
+		WorkingDir:   "/srv",
+	}
+	expected := `working directory set to "/srv"; ` +
+		`environment variable "ADDED" added with value "2"; ` +
+		`environment variable "PATH" changed to "/usr/bin:/bin"; ` +
+		`environment variable "REMOVED" removed; ` +
+		`label "added" added with value "y"; ` +
+		`label "changed" changed to "new"; ` +
+		`label "removed" removed; ` +
+		`port "443/tcp" added; ` +
+		`port "80/tcp" removed`
+	if changes := describeConfigChanges(&before, &after); changes != expected {
+		t.Errorf("expected %q, got %q", expected, changes)
+	}
+	if changes := describeConfigChanges(&before, &before); changes != "" {
+		t.Errorf("expected no changes, got %q", changes)
+	}
+}
+
+func TestSetStopSignal(t *testing.T) {
+	b := &Builder{
+		OCIv1:  v1.Image{},
+		Docker: docker.V2Image{},
+	}
+	b.Docker.Config = &docker.Config{}
+	for _, tc := range []struct {
+		signal   string
+		expected string
+	}{
+		{"SIGTERM", "SIGTERM"},
+		{"term", "SIGTERM"},
+		{"15", "SIGTERM"},
+		{"SIGIOT", "SIGABRT"},
+		{"34", "SIGRTMIN"},
+		{"RTMIN+3", "SIGRTMIN+3"},
+		{"SIGRTMAX-1", "SIGRTMIN+29"},
+		{"64", "SIGRTMAX"},
+		{"", ""},
+	} {
+		if err := b.SetStopSignal(tc.signal); err != nil {
+			t.Errorf("SetStopSignal(%q) failed: %v", tc.signal, err)
+			continue
+		}
+		if b.OCIv1.Config.StopSignal != tc.expected || b.Docker.Config.StopSignal != tc.expected {
+			t.Errorf("SetStopSignal(%q) set %q and %q, expected %q", tc.signal, b.OCIv1.Config.StopSignal, b.Docker.Config.StopSignal, tc.expected)
+		}
+	}
+	if err := b.SetStopSignal("SIGKILL"); err != nil {
+		t.Fatalf("SetStopSignal(%q) failed: %v", "SIGKILL", err)
+	}
+	for _, signal := range []string{"SIGBOGUS", "0", "32", "65", "-1", "SIGRTMIN-1", "SIGRTMIN+31", "SIGRTMAX+1"} {
+		if err := b.SetStopSignal(signal); err == nil {
+			t.Errorf("SetStopSignal(%q) should have failed", signal)
+		}
+		if b.OCIv1.Config.StopSignal != "SIGKILL" || b.Docker.Config.StopSignal != "SIGKILL" {
+			t.Errorf("SetStopSignal(%q) changed the stop signal to %q and %q", signal, b.OCIv1.Config.StopSignal, b.Docker.Config.StopSignal)
+		}
+	}
+}
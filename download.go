@@ -0,0 +1,79 @@
+package buildah
+
+import (
+	"context"
+	"io"
+
+	"github.com/containers/image/types"
+	"github.com/pkg/errors"
+	"golang.org/x/sync/semaphore"
+)
+
+// limitedReference wraps an image reference so that image sources which are
+// created using it share a limit on the number of blobs which can be read at
+// the same time.
+type limitedReference struct {
+	types.ImageReference
+	semaphore *semaphore.Weighted
+}
+
+// limitedSource is an image source which limits the number of blobs that can
+// be read from it at the same time.
+type limitedSource struct {
+	types.ImageSource
+	reference *limitedReference
+}
+
+// limitedBlob releases its slot in the semaphore when it is closed.
+type limitedBlob struct {
+	io.ReadCloser
+	semaphore *semaphore.Weighted
+	released  bool
+}
+
+// newLimitedReference returns a reference which wraps ref and allows no more
+// than maxParallelDownloads blobs to be read from images opened using it at
+// any given time.  If maxParallelDownloads is not positive, ref is returned
+// unchanged.
+func newLimitedReference(ref types.ImageReference, maxParallelDownloads int) types.ImageReference {
+	if maxParallelDownloads <= 0 {
+		return ref
+	}
+	return &limitedReference{
+		ImageReference: ref,
+		semaphore:      semaphore.NewWeighted(int64(maxParallelDownloads)),
+	}
+}
+
+func (r *limitedReference) NewImageSource(ctx context.Context, sys *types.SystemContext) (types.ImageSource, error) {
+	src, err := r.ImageReference.NewImageSource(ctx, sys)
+	if err != nil {
+		return nil, err
+	}
+	return &limitedSource{ImageSource: src, reference: r}, nil
+}
+
+func (s *limitedSource) Reference() types.ImageReference {
+	return s.reference
+}
+
+func (s *limitedSource) GetBlob(ctx context.Context, blobinfo types.BlobInfo, cache types.BlobInfoCache) (io.ReadCloser, int64, error) {
+	if err := s.reference.semaphore.Acquire(ctx, 1); err != nil {
+		return nil, -1, errors.Wrapf(err, "error waiting to read blob %q", blobinfo.Digest.String())
+	}
+	rc, size, err := s.ImageSource.GetBlob(ctx, blobinfo, cache)
+	if err != nil {
+		s.reference.semaphore.Release(1)
+		return nil, -1, err
+	}
+	return &limitedBlob{ReadCloser: rc, semaphore: s.reference.semaphore}, size, nil
+}
+
+func (b *limitedBlob) Close() error {
+	err := b.ReadCloser.Close()
+	if !b.released {
+		b.semaphore.Release(1)
+		b.released = true
+	}
+	return err
+}
@@ -42,6 +42,7 @@ const (
 type containerImageRef struct {
 	store                 storage.Store
 	compression           archive.Compression
+	layerCompression      []archive.Compression
 	name                  reference.Named
 	names                 []string
 	containerID           string
@@ -56,6 +57,8 @@ type containerImageRef struct {
 	preferredManifestType string
 	exporting             bool
 	squash                bool
+	squashTo              int
+	squashBase            string
 	emptyLayer            bool
 	tarPath               func(path string) (io.ReadCloser, error)
 	parent                string
@@ -81,6 +84,16 @@ type containerImageSource struct {
 	blobDirectory string
 }
 
+// compressionForLayer returns the type of compression which should be applied
+// to the layer at the specified position in the image, counting from the base
+// image's first layer.
+func (i *containerImageRef) compressionForLayer(index int) archive.Compression {
+	if index >= 0 && index < len(i.layerCompression) {
+		return i.layerCompression[index]
+	}
+	return i.compression
+}
+
 func (i *containerImageRef) NewImage(ctx context.Context, sc *types.SystemContext) (types.ImageCloser, error) {
 	src, err := i.NewImageSource(ctx, sc)
 	if err != nil {
@@ -159,6 +172,136 @@ func (i *containerImageRef) extractRootfs() (io.ReadCloser, error) {
 	}), nil
 }
 
+// extractLayers returns an archive of the differences between the layer "from"
+// and the layer "to", which must be one of its descendants.  If "from" is
+// empty, the archive holds the entire contents of "to".
+func (i *containerImageRef) extractLayers(from, to string) (io.ReadCloser, error) {
+	if from != "" {
+		noCompression := archive.Uncompressed
+		rc, err := i.store.Diff(from, to, &storage.DiffOptions{Compression: &noCompression})
+		if err != nil {
+			return nil, errors.Wrapf(err, "error computing differences between layers %q and %q", from, to)
+		}
+		return rc, nil
+	}
+	if to == i.layerID {
+		return i.extractRootfs()
+	}
+	mountPoint, err := i.store.Mount(to, i.mountLabel)
+	if err != nil {
+		return nil, errors.Wrapf(err, "error mounting layer %q", to)
+	}
+	rc, err := i.tarPath(mountPoint)
+	if err != nil {
+		if _, err2 := i.store.Unmount(to, false); err2 != nil {
+			logrus.Debugf("error unmounting layer %q: %v", to, err2)
+		}
+		return nil, errors.Wrapf(err, "error extracting contents of layer %q", to)
+	}
+	return ioutils.NewReadCloserWrapper(rc, func() error {
+		if err = rc.Close(); err != nil {
+			err = errors.Wrapf(err, "error closing tar archive of layer %q", to)
+		}
+		if _, err2 := i.store.Unmount(to, false); err == nil {
+			if err2 != nil {
+				err2 = errors.Wrapf(err2, "error unmounting layer %q", to)
+			}
+			err = err2
+		}
+		return err
+	}), nil
+}
+
+// layerSize returns the size of the uncompressed diff for a layer.
+func (i *containerImageRef) layerSize(layerID string) (int64, error) {
+	layer, err := i.store.Layer(layerID)
+	if err != nil {
+		return -1, errors.Wrapf(err, "unable to locate layer %q", layerID)
+	}
+	if layer.UncompressedSize > 0 {
+		return layer.UncompressedSize, nil
+	}
+	size, err := i.store.DiffSize(layer.Parent, layerID)
+	if err != nil {
+		return -1, errors.Wrapf(err, "error computing size of layer %q", layerID)
+	}
+	return size, nil
+}
+
+// planLayerMerges decides which of the layers above i.squashBase, taken from
+// the list of layers, oldest first, should be merged so that no more than
+// i.squashTo of them are left.  Layers are only merged with the layers next to
+// them.  The adjacent pair with the smallest combined size is merged first,
+// with ties going to the pair closest to the base, and that is repeated until
+// few enough are left.  It returns a map from the topmost layer of each group
+// of merged layers to the layer that the group's contents should be compared
+// to, which is empty if the group starts at the bottom of the image, and the
+// set of layers whose contents will be included with those of a layer above
+// them.
+func (i *containerImageRef) planLayerMerges(layers []string) (map[string]string, map[string]bool, error) {
+	mergeFrom := make(map[string]string)
+	merged := make(map[string]bool)
+	if i.squashTo <= 0 {
+		return mergeFrom, merged, nil
+	}
+	first := 0
+	if i.squashBase != "" {
+		first = -1
+		for index, layerID := range layers {
+			if layerID == i.squashBase {
+				first = index + 1
+				break
+			}
+		}
+		if first == -1 {
+			return nil, nil, errors.Errorf("layer %q, below which layers should not be merged, is not part of the image", i.squashBase)
+		}
+	}
+	candidates := layers[first:]
+	if i.emptyLayer && len(candidates) > 0 && candidates[len(candidates)-1] == i.layerID {
+		candidates = candidates[:len(candidates)-1]
+	}
+	type layerGroup struct {
+		layers []string
+		size   int64
+	}
+	groups := make([]layerGroup, 0, len(candidates))
+	for _, layerID := range candidates {
+		size, err := i.layerSize(layerID)
+		if err != nil {
+			return nil, nil, err
+		}
+		groups = append(groups, layerGroup{layers: []string{layerID}, size: size})
+	}
+	for len(groups) > i.squashTo {
+		smallest := 0
+		for g := 1; g < len(groups)-1; g++ {
+			if groups[g].size+groups[g+1].size < groups[smallest].size+groups[smallest+1].size {
+				smallest = g
+			}
+		}
+		groups[smallest].layers = append(groups[smallest].layers, groups[smallest+1].layers...)
+		groups[smallest].size += groups[smallest+1].size
+		groups = append(groups[:smallest+1], groups[smallest+2:]...)
+	}
+	for _, group := range groups {
+		if len(group.layers) < 2 {
+			continue
+		}
+		bottom, err := i.store.Layer(group.layers[0])
+		if err != nil {
+			return nil, nil, errors.Wrapf(err, "unable to locate layer %q", group.layers[0])
+		}
+		top := group.layers[len(group.layers)-1]
+		mergeFrom[top] = bottom.Parent
+		for _, layerID := range group.layers[:len(group.layers)-1] {
+			merged[layerID] = true
+		}
+		logrus.Debugf("merging layers %q", group.layers)
+	}
+	return mergeFrom, merged, nil
+}
+
 // Build fresh copies of the container configuration structures so that we can edit them
 // without making unintended changes to the original Builder.
 func (i *containerImageRef) createConfigsAndManifests() (v1.Image, v1.Manifest, docker.V2Image, docker.V2S2Manifest, error) {
@@ -255,6 +398,12 @@ func (i *containerImageRef) NewImageSource(ctx context.Context, sc *types.System
 	}
 	logrus.Debugf("layer list: %q", layers)
 
+	// If we're limiting the number of layers, decide which ones to merge.
+	mergeFrom, mergedLayers, err := i.planLayerMerges(layers)
+	if err != nil {
+		return nil, err
+	}
+
 	// Make a temporary directory to hold blobs.
 	path, err := ioutil.TempDir(os.TempDir(), Package)
 	if err != nil {
@@ -278,7 +427,7 @@ func (i *containerImageRef) NewImageSource(ctx context.Context, sc *types.System
 	}
 
 	// Extract each layer and compute its digests, both compressed (if requested) and uncompressed.
-	for _, layerID := range layers {
+	for layerIndex, layerID := range layers {
 		what := fmt.Sprintf("layer %q", layerID)
 		if i.squash {
 			what = fmt.Sprintf("container %q", i.containerID)
@@ -296,9 +445,18 @@ func (i *containerImageRef) NewImageSource(ctx context.Context, sc *types.System
 		if i.emptyLayer && layerID == i.layerID {
 			continue
 		}
+		// If this layer's contents are being merged into a layer above
+		// it, skip it.
+		if mergedLayers[layerID] {
+			continue
+		}
+		mergeBase, merging := mergeFrom[layerID]
+		if merging {
+			what = fmt.Sprintf("layer %q, merged with layers below it", layerID)
+		}
 		// If we're not re-exporting the data, and we're reusing layers individually, reuse
 		// the blobsum and diff IDs.
-		if !i.exporting && !i.squash && layerID != i.layerID {
+		if !i.exporting && !i.squash && !merging && layerID != i.layerID {
 			if layer.UncompressedDigest == "" {
 				return nil, errors.Errorf("unable to look up size of layer %q", layerID)
 			}
@@ -324,7 +482,8 @@ func (i *containerImageRef) NewImageSource(ctx context.Context, sc *types.System
 			continue
 		}
 		// Figure out if we need to change the media type, in case we've changed the compression.
-		omediaType, dmediaType, err = computeLayerMIMEType(what, i.compression)
+		compression := i.compressionForLayer(layerIndex)
+		omediaType, dmediaType, err = computeLayerMIMEType(what, compression)
 		if err != nil {
 			return nil, err
 		}
@@ -340,6 +499,13 @@ func (i *containerImageRef) NewImageSource(ctx context.Context, sc *types.System
 			if err != nil {
 				return nil, err
 			}
+		} else if merging {
+			// Extract the contents of this layer and the ones
+			// that we're merging into it.
+			rc, err = i.extractLayers(mergeBase, layerID)
+			if err != nil {
+				return nil, err
+			}
 		} else {
 			// Extract this layer, one of possibly many.
 			rc, err = i.store.Diff("", layerID, diffOptions)
@@ -359,7 +525,7 @@ func (i *containerImageRef) NewImageSource(ctx context.Context, sc *types.System
 		counter := ioutils.NewWriteCounter(layerFile)
 		multiWriter := io.MultiWriter(counter, destHasher.Hash())
 		// Compress the layer, if we're recompressing it.
-		writer, err := archive.CompressStream(multiWriter, i.compression)
+		writer, err := archive.CompressStream(multiWriter, compression)
 		if err != nil {
 			layerFile.Close()
 			rc.Close()
@@ -372,7 +538,7 @@ func (i *containerImageRef) NewImageSource(ctx context.Context, sc *types.System
 		if err != nil {
 			return nil, errors.Wrapf(err, "error storing %s to file", what)
 		}
-		if i.compression == archive.Uncompressed {
+		if compression == archive.Uncompressed {
 			if size != counter.Count {
 				return nil, errors.Errorf("error storing %s to file: inconsistent layer size (copied %d, wrote %d)", what, size, counter.Count)
 			}
@@ -404,6 +570,31 @@ func (i *containerImageRef) NewImageSource(ctx context.Context, sc *types.System
 		dimage.RootFS.DiffIDs = append(dimage.RootFS.DiffIDs, srcHasher.Digest())
 	}
 
+	// Mark the history entries for layers whose contents were merged into
+	// the layers above them as no longer having layers of their own.
+	if len(mergedLayers) > 0 {
+		nonEmpty := 0
+		for h := range oimage.History {
+			if oimage.History[h].EmptyLayer {
+				continue
+			}
+			if nonEmpty < len(layers) && mergedLayers[layers[nonEmpty]] {
+				oimage.History[h].EmptyLayer = true
+			}
+			nonEmpty++
+		}
+		nonEmpty = 0
+		for h := range dimage.History {
+			if dimage.History[h].EmptyLayer {
+				continue
+			}
+			if nonEmpty < len(layers) && mergedLayers[layers[nonEmpty]] {
+				dimage.History[h].EmptyLayer = true
+			}
+			nonEmpty++
+		}
+	}
+
 	// Build history notes in the image configurations.
 	appendHistory := func(history []v1.History) {
 		for i := range history {
@@ -642,7 +833,7 @@ func (i *containerImageSource) GetBlob(ctx context.Context, blob types.BlobInfo,
 	return ioutils.NewReadCloserWrapper(layerFile, closer), size, nil
 }
 
-func (b *Builder) makeImageRef(options CommitOptions, exporting bool) (types.ImageReference, error) {
+func (b *Builder) makeImageRef(options CommitOptions, exporting bool, configChanges string) (types.ImageReference, error) {
 	var name reference.Named
 	container, err := b.store.Container(b.ContainerID)
 	if err != nil {
@@ -681,6 +872,17 @@ func (b *Builder) makeImageRef(options CommitOptions, exporting bool) (types.Ima
 		created = time.Unix(0, 0)
 	}
 
+	postEmptyLayers := b.AppendedEmptyLayers
+	if configChanges != "" {
+		configCreated := created
+		postEmptyLayers = append(copyHistory(b.AppendedEmptyLayers), v1.History{
+			Created:    &configCreated,
+			CreatedBy:  "buildah config",
+			Comment:    configChanges,
+			EmptyLayer: true,
+		})
+	}
+
 	parent := ""
 	if b.FromImageID != "" {
 		parentDigest := digest.NewDigestFromEncoded(digest.Canonical, b.FromImageID)
@@ -692,6 +894,7 @@ func (b *Builder) makeImageRef(options CommitOptions, exporting bool) (types.Ima
 	ref := &containerImageRef{
 		store:                 b.store,
 		compression:           options.Compression,
+		layerCompression:      options.LayerCompression,
 		name:                  name,
 		names:                 container.Names,
 		containerID:           container.ID,
@@ -706,12 +909,14 @@ func (b *Builder) makeImageRef(options CommitOptions, exporting bool) (types.Ima
 		preferredManifestType: manifestType,
 		exporting:             exporting,
 		squash:                options.Squash,
+		squashTo:              options.SquashTo,
+		squashBase:            options.SquashBase,
 		emptyLayer:            options.EmptyLayer,
 		tarPath:               b.tarPath(&b.IDMappingOptions),
 		parent:                parent,
 		blobDirectory:         options.BlobDirectory,
 		preEmptyLayers:        b.PrependedEmptyLayers,
-		postEmptyLayers:       b.AppendedEmptyLayers,
+		postEmptyLayers:       postEmptyLayers,
 	}
 	return ref, nil
 }
@@ -0,0 +1,121 @@
+package buildah
+
+import (
+	"archive/tar"
+	"bytes"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"testing"
+
+	"github.com/containers/storage"
+)
+
+// layerArchive returns an archive containing one file of the specified size.
+func layerArchive(t *testing.T, name string, size int) io.Reader {
+	var buf bytes.Buffer
+	tw := tar.NewWriter(&buf)
+	if err := tw.WriteHeader(&tar.Header{Name: name, Typeflag: tar.TypeReg, Mode: 0644, Size: int64(size)}); err != nil {
+		t.Fatalf("error writing header for %q: %v", name, err)
+	}
+	if _, err := tw.Write(bytes.Repeat([]byte{'x'}, size)); err != nil {
+		t.Fatalf("error writing contents of %q: %v", name, err)
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatalf("error closing archive: %v", err)
+	}
+	return &buf
+}
+
+func TestPlanLayerMerges(t *testing.T) {
+	if os.Getuid() != 0 {
+		t.Skip("test requires root privileges")
+	}
+	dir, err := ioutil.TempDir("", "buildah-test")
+	if err != nil {
+		t.Fatalf("error creating temporary directory: %v", err)
+	}
+	defer os.RemoveAll(dir)
+	store, err := storage.GetStore(storage.StoreOptions{
+		RunRoot:         filepath.Join(dir, "run"),
+		GraphRoot:       filepath.Join(dir, "root"),
+		GraphDriverName: "vfs",
+	})
+	if err != nil {
+		t.Fatalf("error creating storage: %v", err)
+	}
+	defer func() {
+		if _, err := store.Shutdown(true); err != nil {
+			t.Errorf("error shutting down storage: %v", err)
+		}
+	}()
+
+	// A base layer, followed by four more of varying sizes.
+	var layers []string
+	parent := ""
+	for i, size := range []int{4096, 40960, 1024, 2048, 81920} {
+		layer, _, err := store.PutLayer("", parent, nil, "", false, nil, layerArchive(t, string(rune('a'+i)), size))
+		if err != nil {
+			t.Fatalf("error creating layer %d: %v", i, err)
+		}
+		layers = append(layers, layer.ID)
+		parent = layer.ID
+	}
+
+	for _, test := range []struct {
+		squashTo  int
+		mergeFrom map[string]string
+		merged    []string
+	}{
+		{4, map[string]string{}, nil},
+		// 1024+2048 is the smallest pair.
+		{3, map[string]string{layers[3]: layers[1]}, []string{layers[2]}},
+		// Then 40960+3072 is smaller than 3072+81920.
+		{2, map[string]string{layers[3]: layers[0]}, []string{layers[1], layers[2]}},
+		{1, map[string]string{layers[4]: layers[0]}, []string{layers[1], layers[2], layers[3]}},
+	} {
+		ref := &containerImageRef{store: store, layerID: layers[4], squashTo: test.squashTo, squashBase: layers[0]}
+		mergeFrom, merged, err := ref.planLayerMerges(layers)
+		if err != nil {
+			t.Fatalf("error planning merges to %d layers: %v", test.squashTo, err)
+		}
+		if !reflect.DeepEqual(mergeFrom, test.mergeFrom) {
+			t.Errorf("squashing to %d layers, expected merges %v, got %v", test.squashTo, test.mergeFrom, mergeFrom)
+		}
+		var mergedList []string
+		for layer := range merged {
+			mergedList = append(mergedList, layer)
+		}
+		sort.Strings(mergedList)
+		sort.Strings(test.merged)
+		if !reflect.DeepEqual(mergedList, test.merged) {
+			t.Errorf("squashing to %d layers, expected to merge %v, got %v", test.squashTo, test.merged, mergedList)
+		}
+	}
+
+	// A merged layer holds the contents of the layers that went into it.
+	ref := &containerImageRef{store: store, layerID: layers[4]}
+	rc, err := ref.extractLayers(layers[0], layers[3])
+	if err != nil {
+		t.Fatalf("error extracting merged layers: %v", err)
+	}
+	var names []string
+	tr := tar.NewReader(rc)
+	for hdr, err := tr.Next(); err == nil; hdr, err = tr.Next() {
+		names = append(names, hdr.Name)
+	}
+	if err := rc.Close(); err != nil {
+		t.Errorf("error closing merged layers: %v", err)
+	}
+	if !reflect.DeepEqual(names, []string{"b", "c", "d"}) {
+		t.Errorf("expected merged layers to contain [b c d], got %v", names)
+	}
+
+	ref = &containerImageRef{store: store, squashTo: 1, squashBase: "nonexistent"}
+	if _, _, err = ref.planLayerMerges(layers); err == nil {
+		t.Errorf("expected an error when the base layer isn't part of the image")
+	}
+}
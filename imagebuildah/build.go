@@ -13,6 +13,7 @@ import (
 	"sort"
 	"strconv"
 	"strings"
+	"syscall"
 	"time"
 
 	"github.com/containers/buildah"
@@ -29,6 +30,7 @@ import (
 	"github.com/containers/storage/pkg/archive"
 	securejoin "github.com/cyphar/filepath-securejoin"
 	docker "github.com/fsouza/go-dockerclient"
+	"github.com/opencontainers/go-digest"
 	v1 "github.com/opencontainers/image-spec/specs-go/v1"
 	"github.com/opencontainers/runtime-spec/specs-go"
 	"github.com/openshift/imagebuilder"
@@ -46,6 +48,11 @@ const (
 	Bzip2        = archive.Bzip2
 	Xz           = archive.Xz
 	Uncompressed = archive.Uncompressed
+
+	// EmbeddedContainerfileLabel is the label which holds the contents of
+	// the Dockerfiles used to build an image, if BuildOptions specified
+	// that they should be embedded in it as a label.
+	EmbeddedContainerfileLabel = "io.buildah.containerfile"
 )
 
 // Mount is a mountpoint for the build container.
@@ -84,6 +91,10 @@ type BuildOptions struct {
 	Compression archive.Compression
 	// Arguments which can be interpolated into Dockerfiles
 	Args map[string]string
+	// RequiredArgs is a list of the names of arguments which must be given
+	// values, either in Args or as defaults in the Dockerfiles' ARG
+	// instructions, for the build to proceed.
+	RequiredArgs []string
 	// Name of the image to write to.
 	Output string
 	// Additional tags to add to the image that we write, if we know of a
@@ -115,6 +126,10 @@ type BuildOptions struct {
 	OutputFormat string
 	// SystemContext holds parameters used for authentication.
 	SystemContext *types.SystemContext
+	// DestinationSystemContext, if set, is used instead of SystemContext
+	// when writing images, allowing settings like TLS verification to
+	// differ between pulling base images and writing the built image.
+	DestinationSystemContext *types.SystemContext
 	// NamespaceOptions controls how we set up namespaces processes that we
 	// might need when handling RUN instructions.
 	NamespaceOptions []buildah.NamespaceOption
@@ -148,6 +163,11 @@ type BuildOptions struct {
 	// Squash tells the builder to produce an image with a single layer
 	// instead of with possibly more than one layer.
 	Squash bool
+	// SquashTo, if greater than zero, tells the builder to merge the layers
+	// that it adds to the final stage's base image, combining adjacent
+	// layers with the smallest combined sizes first, until no more than
+	// SquashTo of them remain.  It can not be used along with Squash.
+	SquashTo int
 	// Labels metadata for an image
 	Labels []string
 	// Annotation metadata for an image
@@ -159,6 +179,18 @@ type BuildOptions struct {
 	// NoCache tells the builder to build the image from scratch without checking for a cache.
 	// It creates a new set of cached images for the build.
 	NoCache bool
+	// NoCacheStages is a list of the names or numbers of stages which
+	// should be built without checking for cached images, while other
+	// stages continue to use them.  It has no effect if NoCache is set.
+	NoCacheStages []string
+	// ChownSource controls where user and group names used in the --chown
+	// flags of COPY and ADD instructions are looked up: either
+	// buildah.ChownSourceTarget (the default) or buildah.ChownSourceHost.
+	ChownSource string
+	// PrintCacheKeys causes the key which is used to look for a cached
+	// image for each instruction, and whether or not one was found, to be
+	// written to Out.  It can only be used when Layers is set.
+	PrintCacheKeys bool
 	// RemoveIntermediateCtrs tells the builder whether to remove intermediate containers used
 	// during the build process. Default is true.
 	RemoveIntermediateCtrs bool
@@ -169,6 +201,26 @@ type BuildOptions struct {
 	BlobDirectory string
 	// Target the targeted FROM in the Dockerfile to build
 	Target string
+	// MaxParallelDownloads is the maximum number of layer blobs which
+	// will be downloaded at the same time when pulling base images.
+	MaxParallelDownloads int
+	// ProvenanceFile is the name of a file to which an in-toto statement
+	// with a SLSA provenance predicate describing the build, including
+	// the base images and Dockerfiles which were used and the build's
+	// parameters, should be written.
+	ProvenanceFile string
+	// DefaultShell is the shell, along with its arguments, which is used
+	// to run shell-form RUN instructions in stages which don't set one
+	// using a SHELL instruction.  If not set, "/bin/sh -c" is used.
+	DefaultShell []string
+	// EmbedContainerfile, if set, causes the contents of the Dockerfiles
+	// to be stored in the image that we build.  If it is "label", they
+	// are stored as the value of the EmbeddedContainerfileLabel label.
+	// Otherwise, it should be an absolute path, and they are written to a
+	// file at that location in the image.  The contents are stored as
+	// they were read, without build arguments being substituted into
+	// them.
+	EmbedContainerfile string
 }
 
 // Executor is a buildah-based implementation of the imagebuilder.Executor
@@ -195,6 +247,7 @@ type Executor struct {
 	err                            io.Writer
 	signaturePolicyPath            string
 	systemContext                  *types.SystemContext
+	destSystemContext              *types.SystemContext
 	reportWriter                   io.Writer
 	isolation                      buildah.Isolation
 	namespaceOptions               []buildah.NamespaceOption
@@ -206,20 +259,33 @@ type Executor struct {
 	defaultMountsFilePath          string
 	iidfile                        string
 	squash                         bool
+	squashTo                       int
 	labels                         []string
 	annotations                    []string
 	layers                         bool
 	useCache                       bool
+	noCacheStages                  map[string]bool
+	printCacheKeys                 bool
+	chownSource                    string
 	removeIntermediateCtrs         bool
 	forceRmIntermediateCtrs        bool
 	imageMap                       map[string]string           // Used to map images that we create to handle the AS construct.
 	containerMap                   map[string]*buildah.Builder // Used to map from image names to only-created-for-the-rootfs containers.
 	baseMap                        map[string]bool             // Holds the names of every base image, as given.
 	rootfsMap                      map[string]bool             // Holds the names of every stage whose rootfs is referenced in a COPY or ADD instruction.
+	stagePositions                 map[string]int              // Holds the position of every stage, by name and by number.
 	blobDirectory                  string
 	excludes                       []string
 	unusedArgs                     map[string]struct{}
 	buildArgs                      map[string]string
+	maxParallelDownloads           int
+	target                         string
+	provenanceFile                 string
+	provenanceMaterials            []provenanceMaterial
+	dockerfileDigests              map[string]digest.Digest
+	defaultShell                   []string
+	embedContainerfile             string
+	containerfileContents          []byte
 }
 
 // StageExecutor bundles up what we need to know when executing one stage of a
@@ -244,9 +310,12 @@ type StageExecutor struct {
 	volumeCache     map[string]string
 	volumeCacheInfo map[string]os.FileInfo
 	mountPoint      string
-	copyFrom        string // Used to keep track of the --from flag from COPY and ADD
+	copyFrom        string        // Used to keep track of the --from flag from COPY and ADD
+	runMounts       []specs.Mount // Used to keep track of the --mount flags from RUN
+	addChecksum     string        // Used to keep track of the --checksum flag from ADD
 	output          string
 	containerIDs    []string
+	baseTopLayer    string // Used to keep track of the base image's top layer, above which layers can be merged
 }
 
 // builtinAllowedBuildArgs is list of built-in allowed build args.  Normally we
@@ -544,9 +613,10 @@ func (s *StageExecutor) Copy(excludes []string, copies ...imagebuilder.Copy) err
 					_, srcNameSecure := filepath.Split(srcSecure)
 					if srcName != srcNameSecure {
 						options := buildah.AddAndCopyOptions{
-							Chown:      copy.Chown,
-							ContextDir: contextDir,
-							Excludes:   copyExcludes,
+							Chown:       copy.Chown,
+							ChownSource: s.executor.chownSource,
+							ContextDir:  contextDir,
+							Excludes:    copyExcludes,
 						}
 						if err := s.builder.Add(filepath.Join(copy.Dest, srcName), copy.Download, options, srcSecure); err != nil {
 							return err
@@ -562,10 +632,14 @@ func (s *StageExecutor) Copy(excludes []string, copies ...imagebuilder.Copy) err
 			}
 			options := buildah.AddAndCopyOptions{
 				Chown:            copy.Chown,
+				ChownSource:      s.executor.chownSource,
 				ContextDir:       contextDir,
 				Excludes:         copyExcludes,
 				IDMappingOptions: idMappingOptions,
 			}
+			if copy.Download {
+				options.Checksum = s.addChecksum
+			}
 			if err := s.builder.Add(copy.Dest, copy.Download, options, sources...); err != nil {
 				return err
 			}
@@ -609,7 +683,7 @@ func (s *StageExecutor) Run(run imagebuilder.Run, config docker.Config) error {
 		Runtime:          s.executor.runtime,
 		Args:             s.executor.runtimeArgs,
 		NoPivot:          os.Getenv("BUILDAH_NOPIVOT") != "",
-		Mounts:           convertMounts(s.executor.transientMounts),
+		Mounts:           append(convertMounts(s.executor.transientMounts), s.runMounts...),
 		Env:              config.Env,
 		User:             config.User,
 		WorkingDir:       config.WorkingDir,
@@ -629,9 +703,12 @@ func (s *StageExecutor) Run(run imagebuilder.Run, config docker.Config) error {
 
 	args := run.Args
 	if run.Shell {
-		if len(config.Shell) > 0 && s.builder.Format == buildah.Dockerv2ImageManifest {
+		switch {
+		case len(config.Shell) > 0 && (s.builder.Format == buildah.Dockerv2ImageManifest || len(s.executor.defaultShell) > 0):
 			args = append(config.Shell, args...)
-		} else {
+		case len(s.executor.defaultShell) > 0:
+			args = append(append([]string{}, s.executor.defaultShell...), args...)
+		default:
 			args = append([]string{"/bin/sh", "-c"}, args...)
 		}
 	}
@@ -639,6 +716,11 @@ func (s *StageExecutor) Run(run imagebuilder.Run, config docker.Config) error {
 		return err
 	}
 	err := s.builder.Run(args, options)
+	if exitErr, ok := errors.Cause(err).(*exec.ExitError); ok {
+		if status, ok := exitErr.Sys().(syscall.WaitStatus); ok && status.Exited() {
+			err = &ErrRunExit{ExitCode: status.ExitStatus(), Err: err}
+		}
+	}
 	if err2 := s.volumeCacheRestore(); err2 != nil {
 		if err == nil {
 			return err2
@@ -666,7 +748,7 @@ func (s *StageExecutor) UnrecognizedInstruction(step *imagebuilder.Step) error {
 		logrus.Errorf("+(UNHANDLED LOGLEVEL) %#v", step)
 	}
 
-	return errors.Errorf(err)
+	return &ErrParse{Err: errors.Errorf(err)}
 }
 
 // NewExecutor creates a new instance of the imagebuilder.Executor interface.
@@ -693,6 +775,7 @@ func NewExecutor(store storage.Store, options BuildOptions, mainNode *parser.Nod
 		additionalTags:                 options.AdditionalTags,
 		signaturePolicyPath:            options.SignaturePolicyPath,
 		systemContext:                  options.SystemContext,
+		destSystemContext:              options.DestinationSystemContext,
 		log:                            options.Log,
 		in:                             options.In,
 		out:                            options.Out,
@@ -708,19 +791,32 @@ func NewExecutor(store storage.Store, options BuildOptions, mainNode *parser.Nod
 		defaultMountsFilePath:          options.DefaultMountsFilePath,
 		iidfile:                        options.IIDFile,
 		squash:                         options.Squash,
+		squashTo:                       options.SquashTo,
 		labels:                         append([]string{}, options.Labels...),
 		annotations:                    append([]string{}, options.Annotations...),
 		layers:                         options.Layers,
 		useCache:                       !options.NoCache,
+		noCacheStages:                  make(map[string]bool),
+		printCacheKeys:                 options.PrintCacheKeys,
+		chownSource:                    options.ChownSource,
 		removeIntermediateCtrs:         options.RemoveIntermediateCtrs,
 		forceRmIntermediateCtrs:        options.ForceRmIntermediateCtrs,
 		imageMap:                       make(map[string]string),
 		containerMap:                   make(map[string]*buildah.Builder),
 		baseMap:                        make(map[string]bool),
 		rootfsMap:                      make(map[string]bool),
+		stagePositions:                 make(map[string]int),
 		blobDirectory:                  options.BlobDirectory,
 		unusedArgs:                     make(map[string]struct{}),
 		buildArgs:                      options.Args,
+		maxParallelDownloads:           options.MaxParallelDownloads,
+		target:                         options.Target,
+		provenanceFile:                 options.ProvenanceFile,
+		defaultShell:                   options.DefaultShell,
+		embedContainerfile:             options.EmbedContainerfile,
+	}
+	if exec.destSystemContext == nil {
+		exec.destSystemContext = exec.systemContext
 	}
 	if exec.err == nil {
 		exec.err = os.Stderr
@@ -742,6 +838,22 @@ func NewExecutor(store storage.Store, options BuildOptions, mainNode *parser.Nod
 			exec.unusedArgs[arg] = struct{}{}
 		}
 	}
+	for _, stage := range options.NoCacheStages {
+		exec.noCacheStages[stage] = true
+	}
+	if options.ChownSource != "" && options.ChownSource != buildah.ChownSourceTarget && options.ChownSource != buildah.ChownSourceHost {
+		return nil, errors.Errorf("invalid source %q for user and group names, expected %q or %q", options.ChownSource, buildah.ChownSourceTarget, buildah.ChownSourceHost)
+	}
+	if options.SquashTo < 0 {
+		return nil, errors.Errorf("invalid number of layers to squash to: %d", options.SquashTo)
+	}
+	if options.Squash && options.SquashTo > 0 {
+		return nil, errors.Errorf("--squash and --squash-to can not be used together")
+	}
+	if options.PrintCacheKeys && !options.Layers {
+		return nil, errors.Errorf("cache keys can only be printed when intermediate images are being cached using --layers")
+	}
+	defaultedArgs := make(map[string]bool)
 	for _, line := range mainNode.Children {
 		node := line
 		for node != nil { // tokens on this line, though we only care about the first
@@ -756,11 +868,26 @@ func NewExecutor(store storage.Store, options BuildOptions, mainNode *parser.Nod
 					if _, stillUnused := exec.unusedArgs[list[0]]; stillUnused {
 						delete(exec.unusedArgs, list[0])
 					}
+					if len(list) > 1 {
+						defaultedArgs[list[0]] = true
+					}
 				}
 			}
 			break
 		}
 	}
+	var missingArgs []string
+	for _, arg := range options.RequiredArgs {
+		if _, isSet := options.Args[arg]; isSet || defaultedArgs[arg] {
+			continue
+		}
+		if !util.StringInSlice(arg, missingArgs) {
+			missingArgs = append(missingArgs, arg)
+		}
+	}
+	if len(missingArgs) > 0 {
+		return nil, errors.Errorf("required build arguments were neither given values using --build-arg nor given default values in ARG instructions: %s", strings.Join(missingArgs, ", "))
+	}
 	return &exec, nil
 }
 
@@ -814,6 +941,7 @@ func (s *StageExecutor) prepare(ctx context.Context, stage imagebuilder.Stage, f
 		CommonBuildOpts:       s.executor.commonBuildOptions,
 		DefaultMountsFilePath: s.executor.defaultMountsFilePath,
 		Format:                s.executor.outputFormat,
+		MaxParallelDownloads:  s.executor.maxParallelDownloads,
 	}
 
 	// Check and see if the image is a pseudonym for the end result of a
@@ -823,7 +951,18 @@ func (s *StageExecutor) prepare(ctx context.Context, stage imagebuilder.Stage, f
 	}
 	builder, err = buildah.NewBuilder(ctx, s.executor.store, builderOptions)
 	if err != nil {
-		return nil, errors.Wrapf(err, "error creating build container")
+		err = errors.Wrapf(err, "error creating build container")
+		if builderOptions.FromImage != "" && builderOptions.FromImage != "scratch" {
+			if _, _, findErr := util.FindImage(s.executor.store, "", s.executor.systemContext, builderOptions.FromImage); findErr != nil {
+				// We didn't have the image, so we must have
+				// failed to pull it.
+				err = &ErrPull{Image: builderOptions.FromImage, Err: err}
+			}
+		}
+		return nil, err
+	}
+	if _, isPreviousStage := s.executor.imageMap[from]; !isPreviousStage {
+		s.executor.recordBaseImage(builder.FromImage, builder.FromImageID)
 	}
 
 	if initializeIBConfig {
@@ -948,10 +1087,122 @@ func (s *StageExecutor) getImageRootfs(ctx context.Context, stage imagebuilder.S
 	return builder.MountPoint, nil
 }
 
+// checkStageDependency returns an error if name refers to this stage, or to a
+// stage which follows it in the Dockerfile.  Stages are built one at a time, in
+// order, so a stage can only use the contents of the stages before it, all of
+// which will have been completed.
+func (s *StageExecutor) checkStageDependency(name string) error {
+	position, isStage := s.executor.stagePositions[name]
+	if !isStage || position < s.index {
+		return nil
+	}
+	if position == s.index {
+		return errors.Errorf("stage %q can not refer to itself", name)
+	}
+	return errors.Errorf("stage %q has not been built yet: only earlier stages can be referred to", name)
+}
+
+// getRunMount parses the argument of a RUN instruction's --mount flag and
+// returns the corresponding mount.  Only bind mounts are supported.  If a
+// "from" value is given, the source is located in the root filesystem of the
+// named stage or image, otherwise it is located in the context directory.  The
+// mount is read-only unless the "rw" option is given, which is only permitted
+// for mounts from the context directory, so that a RUN instruction can write
+// files back into the build context on the host.
+func (s *StageExecutor) getRunMount(ctx context.Context, stage imagebuilder.Stage, spec string) (specs.Mount, error) {
+	var mountType, from, source, target string
+	readWrite := false
+	for _, val := range strings.Split(spec, ",") {
+		kv := strings.SplitN(val, "=", 2)
+		switch kv[0] {
+		case "ro", "readonly", "rw", "readwrite":
+			value := true
+			if len(kv) > 1 {
+				var err error
+				if value, err = strconv.ParseBool(kv[1]); err != nil {
+					return specs.Mount{}, errors.Wrapf(err, "invalid value for mount option %q", kv[0])
+				}
+			}
+			readWrite = value == (kv[0] == "rw" || kv[0] == "readwrite")
+		case "type", "from", "src", "source", "target", "dst", "destination":
+			if len(kv) == 1 {
+				return specs.Mount{}, errors.Errorf("option %q requires a value", kv[0])
+			}
+			switch kv[0] {
+			case "type":
+				mountType = kv[1]
+			case "from":
+				from = kv[1]
+			case "src", "source":
+				source = kv[1]
+			default:
+				target = kv[1]
+			}
+		default:
+			return specs.Mount{}, errors.Errorf("unsupported mount option %q", kv[0])
+		}
+	}
+	if mountType != "bind" {
+		return specs.Mount{}, errors.Errorf("unsupported mount type %q", mountType)
+	}
+	if target == "" {
+		return specs.Mount{}, errors.Errorf("mount target must be specified")
+	}
+	if !filepath.IsAbs(target) {
+		target = filepath.Join(string(os.PathSeparator), target)
+	}
+	root := s.executor.contextDir
+	if from != "" {
+		if readWrite {
+			return specs.Mount{}, errors.Errorf("only the build context can be mounted read-write, not the contents of %q", from)
+		}
+		if err := s.checkStageDependency(from); err != nil {
+			return specs.Mount{}, err
+		}
+		if otherStage, ok := s.executor.stages[from]; ok && otherStage.index < s.index {
+			root = otherStage.mountPoint
+		} else {
+			mountPoint, err := s.getImageRootfs(ctx, stage, from)
+			if err != nil {
+				return specs.Mount{}, errors.Errorf("no stage or image found with name %q", from)
+			}
+			root = mountPoint
+		}
+	}
+	sourcePath, err := securejoin.SecureJoin(root, source)
+	if err != nil {
+		return specs.Mount{}, errors.Wrapf(err, "error resolving %q under %q", source, root)
+	}
+	if _, err = os.Stat(sourcePath); err != nil {
+		return specs.Mount{}, errors.Wrapf(err, "error checking for mount source %q", source)
+	}
+	mode := "ro"
+	if readWrite {
+		mode = "rw"
+	}
+	return specs.Mount{
+		Type:        "bind",
+		Source:      sourcePath,
+		Destination: target,
+		Options:     []string{"bind", mode},
+	}, nil
+}
+
+// noteErrorLine records the line of the Dockerfile which contained the
+// instruction that we were processing in a parse error which doesn't already
+// note one.
+func noteErrorLine(err error, node *parser.Node) error {
+	if parseErr, ok := errors.Cause(err).(*ErrParse); ok && parseErr.Line == 0 {
+		parseErr.Line = node.StartLine
+	}
+	return err
+}
+
 // Execute runs each of the steps in the stage's parsed tree, in turn.
 func (s *StageExecutor) Execute(ctx context.Context, stage imagebuilder.Stage, base string) (imgID string, ref reference.Canonical, err error) {
 	ib := stage.Builder
-	checkForLayers := s.executor.layers && s.executor.useCache
+	cachingEnabled := s.executor.useCache && !s.executor.noCacheStages[stage.Name] && !s.executor.noCacheStages[fmt.Sprintf("%d", stage.Position)]
+	checkForLayers := s.executor.layers && cachingEnabled
 	moreStages := s.index < s.stages-1
 	lastStage := !moreStages
 	imageIsUsedLater := moreStages && (s.executor.baseMap[stage.Name] || s.executor.baseMap[fmt.Sprintf("%d", stage.Position)])
@@ -972,6 +1223,7 @@ func (s *StageExecutor) Execute(ctx context.Context, stage imagebuilder.Stage, b
 	if _, err := s.prepare(ctx, stage, base, true, true); err != nil {
 		return "", nil, err
 	}
+	s.baseTopLayer = s.builder.TopLayer
 	children := stage.Node.Children
 
 	// A helper function to only log "COMMIT" as an explicit step if it's
@@ -998,12 +1250,18 @@ func (s *StageExecutor) Execute(ctx context.Context, stage imagebuilder.Stage, b
 
 	if len(children) == 0 {
 		// There are no steps.
-		if s.builder.FromImageID == "" || s.executor.squash {
+		if s.builder.FromImageID == "" || s.executor.squash || (lastStage && s.executor.embedContainerfile != "") {
 			// We either don't have a base image, or we need to
-			// squash the contents of the base image.  Whichever is
-			// the case, we need to commit() to create a new image.
+			// squash the contents of the base image, or we need to
+			// add the Dockerfiles to it.  Whichever is the case, we
+			// need to commit() to create a new image.
+			if lastStage {
+				if err := s.embedContainerfile(); err != nil {
+					return "", nil, err
+				}
+			}
 			logCommit(s.output, -1)
-			if imgID, ref, err = s.commit(ctx, ib, s.executor.getCreatedBy(nil), false, s.output); err != nil {
+			if imgID, ref, err = s.commit(ctx, ib, s.executor.getCreatedBy(nil), false, s.output, lastStage); err != nil {
 				return "", nil, errors.Wrapf(err, "error committing base container")
 			}
 		} else {
@@ -1018,12 +1276,16 @@ func (s *StageExecutor) Execute(ctx context.Context, stage imagebuilder.Stage, b
 	}
 
 	for i, node := range children {
+		// Stop if the build has been canceled.
+		if err := ctx.Err(); err != nil {
+			return "", nil, errors.Wrapf(err, "build interrupted")
+		}
 		moreInstructions := i < len(children)-1
 		lastInstruction := !moreInstructions
 		// Resolve any arguments in this instruction.
 		step := ib.Step()
 		if err := step.Resolve(node); err != nil {
-			return "", nil, errors.Wrapf(err, "error resolving step %+v", *node)
+			return "", nil, &ErrParse{Line: node.StartLine, Err: errors.Wrapf(err, "error resolving step %+v", *node)}
 		}
 		logrus.Debugf("Parsed Step: %+v", *step)
 		if !s.executor.quiet {
@@ -1039,6 +1301,9 @@ func (s *StageExecutor) Execute(ctx context.Context, stage imagebuilder.Stage, b
 			if strings.Contains(n, "--from") && (command == "COPY" || command == "ADD") {
 				var mountPoint string
 				arr := strings.Split(n, "=")
+				if err := s.checkStageDependency(arr[1]); err != nil {
+					return "", nil, errors.Wrapf(err, "%s --from=%s", command, arr[1])
+				}
 				otherStage, ok := s.executor.stages[arr[1]]
 				if !ok {
 					if mountPoint, err = s.getImageRootfs(ctx, stage, arr[1]); err != nil {
@@ -1052,6 +1317,45 @@ func (s *StageExecutor) Execute(ctx context.Context, stage imagebuilder.Stage, b
 			}
 		}
 
+		// Check for a --checksum flag if the step command is ADD, and
+		// remove it from the list of flags, since imagebuilder only
+		// accepts --chown for ADD.  It only applies to this one
+		// instruction.
+		s.addChecksum = ""
+		if strings.ToUpper(step.Command) == "ADD" {
+			flags := make([]string, 0, len(step.Flags))
+			for _, n := range step.Flags {
+				if strings.HasPrefix(n, "--checksum=") {
+					s.addChecksum = strings.TrimPrefix(n, "--checksum=")
+					continue
+				}
+				flags = append(flags, n)
+			}
+			step.Flags = flags
+		}
+
+		// Check for --mount flags if the step command is RUN, and
+		// note the mounts that we'll need to provide for it.  They
+		// only last for the duration of this one instruction.  If
+		// any of them let the instruction write to the build context,
+		// we have to actually run it, even if there's a cached image
+		// which we could use instead, so that those writes happen.
+		s.runMounts = nil
+		writesToContext := false
+		if strings.ToUpper(step.Command) == "RUN" {
+			for _, n := range step.Flags {
+				if !strings.HasPrefix(n, "--mount=") {
+					continue
+				}
+				mount, err := s.getRunMount(ctx, stage, strings.TrimPrefix(n, "--mount="))
+				if err != nil {
+					return "", nil, errors.Wrapf(err, "RUN %s", n)
+				}
+				s.runMounts = append(s.runMounts, mount)
+				writesToContext = writesToContext || util.StringInSlice("rw", mount.Options)
+			}
+		}
+
 		// Determine if there are any RUN instructions to be run after
 		// this step.  If not, we won't have to bother preserving the
 		// contents of any volumes declared between now and when we
@@ -1067,7 +1371,7 @@ func (s *StageExecutor) Execute(ctx context.Context, stage imagebuilder.Stage, b
 			err := ib.Run(step, s, noRunsRemaining)
 			if err != nil {
 				logrus.Debugf("%v", errors.Wrapf(err, "error building at step %+v", *step))
-				return "", nil, errors.Wrapf(err, "error building at STEP \"%s\"", step.Message)
+				return "", nil, errors.Wrapf(noteErrorLine(err, node), "error building at STEP \"%s\"", step.Message)
 			}
 			if moreInstructions {
 				// There are still more instructions to process
@@ -1083,8 +1387,13 @@ func (s *StageExecutor) Execute(ctx context.Context, stage imagebuilder.Stage, b
 				// an image, but only if it's the last one, or
 				// if it's used as the basis for a later stage.
 				if lastStage || imageIsUsedLater {
+					if lastStage {
+						if err := s.embedContainerfile(); err != nil {
+							return "", nil, err
+						}
+					}
 					logCommit(s.output, i)
-					imgID, ref, err = s.commit(ctx, ib, s.executor.getCreatedBy(node), false, s.output)
+					imgID, ref, err = s.commit(ctx, ib, s.executor.getCreatedBy(node), false, s.output, lastStage)
 					if err != nil {
 						return "", nil, errors.Wrapf(err, "error committing container for step %+v", *step)
 					}
@@ -1113,10 +1422,30 @@ func (s *StageExecutor) Execute(ctx context.Context, stage imagebuilder.Stage, b
 		// If we're using the cache, and we've managed to stick with
 		// cached images so far, look for one that matches what we
 		// expect to produce for this instruction.
-		if checkForLayers && !(s.executor.squash && lastInstruction && lastStage) {
-			cacheID, err = s.layerExists(ctx, node, children[:i])
+		// If we're going to be adding the Dockerfiles to the final image,
+		// we can't reuse a cached image for the last instruction, since
+		// the Dockerfiles may have changed in ways that didn't change
+		// the instructions.
+		embedding := s.executor.embedContainerfile != "" && lastInstruction && lastStage
+		cacheSkipReason := ""
+		switch {
+		case !cachingEnabled:
+			cacheSkipReason = "caching is disabled"
+		case !checkForLayers:
+			cacheSkipReason = "an earlier instruction was not found in the cache"
+		case (s.executor.squash || s.executor.squashTo > 0) && lastInstruction && lastStage:
+			cacheSkipReason = "the last instruction is never cached when squashing"
+		case embedding:
+			cacheSkipReason = "the last instruction is never cached when embedding the Dockerfile"
+		case writesToContext:
+			cacheSkipReason = "the instruction writes to the build context"
+		}
+		cacheKey := s.cacheKey(node)
+		filesChanged := false
+		if cacheSkipReason == "" {
+			cacheID, filesChanged, err = s.layerExists(ctx, node, children[:i])
 			if err != nil {
-				return "", nil, errors.Wrap(err, "error checking if cached image exists from a previous build")
+				return "", nil, &ErrCache{Err: errors.Wrap(err, "error checking if cached image exists from a previous build")}
 			}
 			if cacheID != "" {
 				// Note the cache hit.
@@ -1126,6 +1455,18 @@ func (s *StageExecutor) Execute(ctx context.Context, stage imagebuilder.Stage, b
 				checkForLayers = false
 			}
 		}
+		if s.executor.printCacheKeys {
+			status := "hit " + cacheID
+			switch {
+			case cacheSkipReason != "":
+				status = "not checked: " + cacheSkipReason
+			case filesChanged:
+				status = "miss: files which it copies have changed since the cached image was built"
+			case cacheID == "":
+				status = "miss"
+			}
+			fmt.Fprintf(s.executor.out, "--> Cache key for stage %s line %d: %s %s\n", stage.Name, node.StartLine, cacheKey, status)
+		}
 
 		if cacheID != "" {
 			// A suitable cached image was found, so just reuse it.
@@ -1156,7 +1497,7 @@ func (s *StageExecutor) Execute(ctx context.Context, stage imagebuilder.Stage, b
 				err := ib.Run(step, s, noRunsRemaining)
 				if err != nil {
 					logrus.Debugf("%v", errors.Wrapf(err, "error building at step %+v", *step))
-					return "", nil, errors.Wrapf(err, "error building at STEP \"%s\"", step.Message)
+					return "", nil, errors.Wrapf(noteErrorLine(err, node), "error building at STEP \"%s\"", step.Message)
 				}
 			}
 		} else {
@@ -1165,11 +1506,20 @@ func (s *StageExecutor) Execute(ctx context.Context, stage imagebuilder.Stage, b
 			err := ib.Run(step, s, noRunsRemaining)
 			if err != nil {
 				logrus.Debugf("%v", errors.Wrapf(err, "error building at step %+v", *step))
-				return "", nil, errors.Wrapf(err, "error building at STEP \"%s\"", step.Message)
+				return "", nil, errors.Wrapf(noteErrorLine(err, node), "error building at STEP \"%s\"", step.Message)
 			}
 			// Create a new image, maybe with a new layer.
+			emptyLayer := !s.stepRequiresLayer(step)
+			if embedding {
+				if err := s.embedContainerfile(); err != nil {
+					return "", nil, err
+				}
+				if s.executor.embedContainerfile != "label" {
+					emptyLayer = false
+				}
+			}
 			logCommit(s.output, i)
-			imgID, ref, err = s.commit(ctx, ib, s.executor.getCreatedBy(node), !s.stepRequiresLayer(step), commitName)
+			imgID, ref, err = s.commit(ctx, ib, s.executor.getCreatedBy(node), emptyLayer, commitName, lastInstruction && lastStage)
 			if err != nil {
 				return "", nil, errors.Wrapf(err, "error committing container for step %+v", *step)
 			}
@@ -1217,7 +1567,7 @@ func (s *StageExecutor) tagExistingImage(ctx context.Context, cacheID, output st
 		return "", nil, err
 	}
 
-	policyContext, err := util.GetPolicyContext(s.executor.systemContext)
+	policyContext, err := util.GetPolicyContext(s.executor.destSystemContext)
 	if err != nil {
 		return "", nil, err
 	}
@@ -1236,7 +1586,7 @@ func (s *StageExecutor) tagExistingImage(ctx context.Context, cacheID, output st
 	if err != nil {
 		return "", nil, errors.Wrapf(err, "error getting source imageReference for %q", cacheID)
 	}
-	manifestBytes, err := cp.Image(ctx, policyContext, dest, src, nil)
+	manifestBytes, err := cp.Image(ctx, policyContext, dest, src, &cp.Options{DestinationCtx: s.executor.destSystemContext})
 	if err != nil {
 		return "", nil, errors.Wrapf(err, "error copying image %q", cacheID)
 	}
@@ -1259,17 +1609,20 @@ func (s *StageExecutor) tagExistingImage(ctx context.Context, cacheID, output st
 
 // layerExists returns true if an intermediate image of currNode exists in the image store from a previous build.
 // It verifies this by checking the parent of the top layer of the image and the history.
-func (s *StageExecutor) layerExists(ctx context.Context, currNode *parser.Node, children []*parser.Node) (string, error) {
+// If an image with a matching history is found, but the files copied by currNode have been
+// modified since that image was built, the returned bool will be true.
+func (s *StageExecutor) layerExists(ctx context.Context, currNode *parser.Node, children []*parser.Node) (string, bool, error) {
+	filesChanged := false
 	// Get the list of images available in the image store
 	images, err := s.executor.store.Images()
 	if err != nil {
-		return "", errors.Wrap(err, "error getting image list from store")
+		return "", false, errors.Wrap(err, "error getting image list from store")
 	}
 	var baseHistory []v1.History
 	if s.builder.FromImageID != "" {
 		baseHistory, err = s.executor.getImageHistory(ctx, s.builder.FromImageID)
 		if err != nil {
-			return "", errors.Wrapf(err, "error getting history of base image %q", s.builder.FromImageID)
+			return "", false, errors.Wrapf(err, "error getting history of base image %q", s.builder.FromImageID)
 		}
 	}
 	for _, image := range images {
@@ -1277,7 +1630,7 @@ func (s *StageExecutor) layerExists(ctx context.Context, currNode *parser.Node,
 		if image.TopLayer != "" {
 			imageTopLayer, err = s.executor.store.Layer(image.TopLayer)
 			if err != nil {
-				return "", errors.Wrapf(err, "error getting top layer info")
+				return "", false, errors.Wrapf(err, "error getting top layer info")
 			}
 		}
 		// If the parent of the top layer of an image is equal to the current build image's top layer,
@@ -1287,7 +1640,7 @@ func (s *StageExecutor) layerExists(ctx context.Context, currNode *parser.Node,
 		if imageTopLayer == nil || (s.builder.TopLayer != "" && (imageTopLayer.Parent == s.builder.TopLayer || imageTopLayer.ID == s.builder.TopLayer)) {
 			history, err := s.executor.getImageHistory(ctx, image.ID)
 			if err != nil {
-				return "", errors.Wrapf(err, "error getting history of %q", image.ID)
+				return "", false, errors.Wrapf(err, "error getting history of %q", image.ID)
 			}
 			// children + currNode is the point of the Dockerfile we are currently at.
 			if s.executor.historyMatches(baseHistory, currNode, history) {
@@ -1295,15 +1648,26 @@ func (s *StageExecutor) layerExists(ctx context.Context, currNode *parser.Node,
 				// a COPY or ADD command.
 				filesMatch, err := s.copiedFilesMatch(currNode, history[len(history)-1].Created)
 				if err != nil {
-					return "", errors.Wrapf(err, "error checking if copied files match")
+					return "", false, errors.Wrapf(err, "error checking if copied files match")
 				}
 				if filesMatch {
-					return image.ID, nil
+					return image.ID, false, nil
 				}
+				filesChanged = true
 			}
 		}
 	}
-	return "", nil
+	return "", filesChanged, nil
+}
+
+// cacheKey returns a digest of the values which a cached image has to match in
+// order to be used instead of processing the instruction in node: the image
+// and layer which the instruction would be processed on top of, and the
+// history entry which processing it would produce.  A cached image for a COPY
+// or ADD instruction also has to have been built after the files it copies
+// were last modified, which the key doesn't reflect.
+func (s *StageExecutor) cacheKey(node *parser.Node) digest.Digest {
+	return digest.FromString(strings.Join([]string{s.builder.FromImageID, s.builder.TopLayer, s.executor.getCreatedBy(node)}, "\n"))
 }
 
 // getImageHistory returns the history of imageID.
@@ -1330,11 +1694,15 @@ func (b *Executor) getCreatedBy(node *parser.Node) string {
 		return "/bin/sh"
 	}
 	if node.Value == "run" {
+		shell := "/bin/sh -c"
+		if len(b.defaultShell) > 0 {
+			shell = strings.Join(b.defaultShell, " ")
+		}
 		buildArgs := b.getBuildArgs()
 		if buildArgs != "" {
-			return "|" + strconv.Itoa(len(strings.Split(buildArgs, " "))) + " " + buildArgs + " /bin/sh -c " + node.Original[4:]
+			return "|" + strconv.Itoa(len(strings.Split(buildArgs, " "))) + " " + buildArgs + " " + shell + " " + node.Original[4:]
 		}
-		return "/bin/sh -c " + node.Original[4:]
+		return shell + " " + node.Original[4:]
 	}
 	return "/bin/sh -c #(nop) " + node.Original
 }
@@ -1471,9 +1839,41 @@ func urlContentModified(url string, historyTime *time.Time) (bool, error) {
 	return true, nil
 }
 
+// embedContainerfile stores the contents of the Dockerfiles in the working
+// container, either as a label or as a file, if we were asked to.
+func (s *StageExecutor) embedContainerfile() error {
+	switch s.executor.embedContainerfile {
+	case "":
+		return nil
+	case "label":
+		s.builder.SetLabel(EmbeddedContainerfileLabel, string(s.executor.containerfileContents))
+		return nil
+	}
+	dest, err := securejoin.SecureJoin(s.mountPoint, s.executor.embedContainerfile)
+	if err != nil {
+		return errors.Wrapf(err, "error resolving %q in container %q", s.executor.embedContainerfile, s.builder.ContainerID)
+	}
+	if err = os.MkdirAll(filepath.Dir(dest), 0755); err != nil {
+		return errors.Wrapf(err, "error creating directory for %q in container %q", s.executor.embedContainerfile, s.builder.ContainerID)
+	}
+	if err = ioutil.WriteFile(dest, s.executor.containerfileContents, 0644); err != nil {
+		return errors.Wrapf(err, "error writing Dockerfiles to %q in container %q", s.executor.embedContainerfile, s.builder.ContainerID)
+	}
+	rootUID, rootGID, err := util.GetHostIDs(s.builder.IDMappingOptions.UIDMap, s.builder.IDMappingOptions.GIDMap, 0, 0)
+	if err != nil {
+		return errors.Wrapf(err, "error determining ownership for %q in container %q", s.executor.embedContainerfile, s.builder.ContainerID)
+	}
+	if err = os.Lchown(dest, int(rootUID), int(rootGID)); err != nil {
+		return errors.Wrapf(err, "error setting ownership of %q in container %q", s.executor.embedContainerfile, s.builder.ContainerID)
+	}
+	return nil
+}
+
 // commit writes the container's contents to an image, using a passed-in tag as
-// the name if there is one, generating a unique ID-based one otherwise.
-func (s *StageExecutor) commit(ctx context.Context, ib *imagebuilder.Builder, createdBy string, emptyLayer bool, output string) (string, reference.Canonical, error) {
+// the name if there is one, generating a unique ID-based one otherwise.  If
+// this is the build's final image, layers above the stage's base image may be
+// merged.
+func (s *StageExecutor) commit(ctx context.Context, ib *imagebuilder.Builder, createdBy string, emptyLayer bool, output string, final bool) (string, reference.Canonical, error) {
 	var imageRef types.ImageReference
 	if output != "" {
 		imageRef2, err := s.executor.resolveNameToImageRef(output)
@@ -1513,7 +1913,9 @@ func (s *StageExecutor) commit(ctx context.Context, ib *imagebuilder.Builder, cr
 	s.builder.SetWorkDir(config.WorkingDir)
 	s.builder.SetEntrypoint(config.Entrypoint)
 	s.builder.SetShell(config.Shell)
-	s.builder.SetStopSignal(config.StopSignal)
+	if err := s.builder.SetStopSignal(config.StopSignal); err != nil {
+		return "", nil, err
+	}
 	if config.Healthcheck != nil {
 		s.builder.SetHealthcheck(&buildahdocker.HealthConfig{
 			Test:        append([]string{}, config.Healthcheck.Test...),
@@ -1560,11 +1962,15 @@ func (s *StageExecutor) commit(ctx context.Context, ib *imagebuilder.Builder, cr
 		SignaturePolicyPath:   s.executor.signaturePolicyPath,
 		ReportWriter:          writer,
 		PreferredManifestType: s.executor.outputFormat,
-		SystemContext:         s.executor.systemContext,
+		SystemContext:         s.executor.destSystemContext,
 		Squash:                s.executor.squash,
 		EmptyLayer:            emptyLayer,
 		BlobDirectory:         s.executor.blobDirectory,
 	}
+	if final && s.executor.squashTo > 0 {
+		options.SquashTo = s.executor.squashTo
+		options.SquashBase = s.baseTopLayer
+	}
 	imgID, _, manifestDigest, err := s.builder.Commit(ctx, imageRef, options)
 	if err != nil {
 		return "", nil, err
@@ -1588,6 +1994,7 @@ func (b *Executor) Build(ctx context.Context, stages imagebuilder.Stages) (image
 	}
 	var cleanupImages []string
 	cleanupStages := make(map[int]*StageExecutor)
+	started := time.Now().UTC()
 
 	cleanup := func() error {
 		var lastErr error
@@ -1650,6 +2057,8 @@ func (b *Executor) Build(ctx context.Context, stages imagebuilder.Stages) (image
 	// filesystem.  Individual stages can use them to determine whether or
 	// not they can skip certain steps near the end of their stages.
 	for _, stage := range stages {
+		b.stagePositions[stage.Name] = stage.Position
+		b.stagePositions[strconv.Itoa(stage.Position)] = stage.Position
 		node := stage.Node // first line
 		for node != nil {  // each line
 			for _, child := range node.Children { // tokens on this line, though we only care about the first
@@ -1678,6 +2087,21 @@ func (b *Executor) Build(ctx context.Context, stages imagebuilder.Stages) (image
 							logrus.Debugf("rootfs: %q", rootfs)
 						}
 					}
+				case "RUN":
+					for _, flag := range child.Flags { // flags for this instruction
+						if !strings.HasPrefix(flag, "--mount=") {
+							continue
+						}
+						for _, option := range strings.Split(flag[8:], ",") {
+							if strings.HasPrefix(option, "from=") {
+								// TODO: this didn't undergo variable and
+								// arg expansion either.
+								rootfs := option[5:]
+								b.rootfsMap[rootfs] = true
+								logrus.Debugf("rootfs: %q", rootfs)
+							}
+						}
+					}
 				}
 				break
 			}
@@ -1685,6 +2109,14 @@ func (b *Executor) Build(ctx context.Context, stages imagebuilder.Stages) (image
 		}
 	}
 
+	// Make sure that any stages for which we've been told not to use the
+	// cache are actually in the Dockerfile.
+	for name := range b.noCacheStages {
+		if _, isStage := b.stagePositions[name]; !isStage {
+			return "", nil, errors.Errorf("caching was disabled for stage %q, but no stage with that name or number is being built", name)
+		}
+	}
+
 	// Run through the build stages, one at a time.
 	for stageIndex, stage := range stages {
 		var lastErr error
@@ -1719,6 +2151,12 @@ func (b *Executor) Build(ctx context.Context, stages imagebuilder.Stages) (image
 			lastErr = err
 		}
 		if lastErr != nil {
+			// If the build was interrupted, there's no point in
+			// keeping the working container around for debugging.
+			if ctx.Err() != nil {
+				cleanupStages[stage.Position] = stageExecutor
+				b.removeIntermediateCtrs = true
+			}
 			return "", nil, lastErr
 		}
 
@@ -1780,6 +2218,12 @@ func (b *Executor) Build(ctx context.Context, stages imagebuilder.Stages) (image
 		}
 	}
 
+	if b.provenanceFile != "" {
+		if err = b.writeProvenance(imageID, started, time.Now().UTC()); err != nil {
+			return imageID, ref, err
+		}
+	}
+
 	return imageID, ref, nil
 }
 
@@ -1796,6 +2240,11 @@ func BuildDockerfiles(ctx context.Context, store storage.Store, options BuildOpt
 			d.Close()
 		}
 	}(dockerfiles...)
+	if options.EmbedContainerfile != "" && options.EmbedContainerfile != "label" && !filepath.IsAbs(options.EmbedContainerfile) {
+		return "", nil, errors.Errorf("error building: location %q for embedding Dockerfiles must be \"label\" or an absolute path", options.EmbedContainerfile)
+	}
+	dockerfileDigesters := make(map[string]digest.Digester)
+	var dockerfileContents []*bytes.Buffer
 
 	for _, dfile := range paths {
 		var data io.ReadCloser
@@ -1852,17 +2301,39 @@ func BuildDockerfiles(ctx context.Context, store storage.Store, options BuildOpt
 			data = *pData
 		}
 
+		// If we're recording provenance, digest the contents as we
+		// parse them.
+		if options.ProvenanceFile != "" {
+			digester := digest.Canonical.Digester()
+			dockerfileDigesters[dfile] = digester
+			data = struct {
+				io.Reader
+				io.Closer
+			}{io.TeeReader(data, digester.Hash()), data}
+		}
+
+		// If we're embedding the Dockerfiles in the image, save a
+		// copy of their contents as we parse them.
+		if options.EmbedContainerfile != "" {
+			contents := new(bytes.Buffer)
+			dockerfileContents = append(dockerfileContents, contents)
+			data = struct {
+				io.Reader
+				io.Closer
+			}{io.TeeReader(data, contents), data}
+		}
+
 		dockerfiles = append(dockerfiles, data)
 	}
 
 	mainNode, err := imagebuilder.ParseDockerfile(dockerfiles[0])
 	if err != nil {
-		return "", nil, errors.Wrapf(err, "error parsing main Dockerfile")
+		return "", nil, &ErrParse{Err: errors.Wrapf(err, "error parsing main Dockerfile")}
 	}
 	for _, d := range dockerfiles[1:] {
 		additionalNode, err := imagebuilder.ParseDockerfile(d)
 		if err != nil {
-			return "", nil, errors.Wrapf(err, "error parsing additional Dockerfile")
+			return "", nil, &ErrParse{Err: errors.Wrapf(err, "error parsing additional Dockerfile")}
 		}
 		mainNode.Children = append(mainNode.Children, additionalNode.Children...)
 	}
@@ -1870,10 +2341,22 @@ func BuildDockerfiles(ctx context.Context, store storage.Store, options BuildOpt
 	if err != nil {
 		return "", nil, errors.Wrapf(err, "error creating build executor")
 	}
+	if len(dockerfileDigesters) > 0 {
+		exec.dockerfileDigests = make(map[string]digest.Digest)
+		for dfile, digester := range dockerfileDigesters {
+			exec.dockerfileDigests[dfile] = digester.Digest()
+		}
+	}
+	for i, contents := range dockerfileContents {
+		if i > 0 && !bytes.HasSuffix(exec.containerfileContents, []byte("\n")) {
+			exec.containerfileContents = append(exec.containerfileContents, '\n')
+		}
+		exec.containerfileContents = append(exec.containerfileContents, contents.Bytes()...)
+	}
 	b := imagebuilder.NewBuilder(options.Args)
 	stages, err := imagebuilder.NewStages(mainNode, b)
 	if err != nil {
-		return "", nil, errors.Wrap(err, "error reading multiple stages")
+		return "", nil, &ErrParse{Err: errors.Wrap(err, "error reading multiple stages")}
 	}
 	if options.Target != "" {
 		stagesTargeted, ok := stages.ThroughTarget(options.Target)
@@ -5,3 +5,61 @@ import "errors"
 var (
 	errDanglingSymlink = errors.New("error evaluating dangling symlink")
 )
+
+// The error types defined below describe the most common reasons for a build
+// to fail.  BuildDockerfiles may add context to them before returning them,
+// so callers should use Cause() from github.com/pkg/errors to find them, e.g.:
+//
+//     switch e := errors.Cause(err).(type) {
+//     case *imagebuildah.ErrRunExit:
+//             os.Exit(e.ExitCode)
+//     }
+//
+// Each of them produces the same message as the error which it describes.
+
+// ErrPull is returned when the image which a stage is based on could neither be
+// found in local storage nor pulled.
+type ErrPull struct {
+	// Image is the name of the image which we were looking for.
+	Image string
+	Err   error
+}
+
+func (e *ErrPull) Error() string {
+	return e.Err.Error()
+}
+
+// ErrRunExit is returned when the command for a RUN instruction exits with a
+// non-zero status.
+type ErrRunExit struct {
+	// ExitCode is the status which the command exited with.
+	ExitCode int
+	Err      error
+}
+
+func (e *ErrRunExit) Error() string {
+	return e.Err.Error()
+}
+
+// ErrParse is returned when a Dockerfile can't be parsed, or when it contains
+// an instruction which we don't know how to process.
+type ErrParse struct {
+	// Line is the line in the Dockerfile where the problem was found, if
+	// known, or 0 if not.
+	Line int
+	Err  error
+}
+
+func (e *ErrParse) Error() string {
+	return e.Err.Error()
+}
+
+// ErrCache is returned when we fail while looking for or reusing an image
+// produced by a previous build when --layers is being used.
+type ErrCache struct {
+	Err error
+}
+
+func (e *ErrCache) Error() string {
+	return e.Err.Error()
+}
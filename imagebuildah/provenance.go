@@ -0,0 +1,162 @@
+package imagebuildah
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"sort"
+	"time"
+
+	"github.com/containers/buildah"
+	"github.com/opencontainers/go-digest"
+	"github.com/pkg/errors"
+)
+
+const (
+	// InTotoStatementType is the type of the in-toto statement which we
+	// write when asked to record the provenance of a build.
+	InTotoStatementType = "https://in-toto.io/Statement/v0.1"
+	// SLSAProvenancePredicateType is the type of the predicate in the
+	// in-toto statement which we write when asked to record the provenance
+	// of a build.
+	SLSAProvenancePredicateType = "https://slsa.dev/provenance/v0.2"
+	// provenanceBuildType identifies the kind of build which is described
+	// by a provenance statement that we generate.
+	provenanceBuildType = "https://github.com/containers/buildah/bud@v1"
+)
+
+// provenanceSubject is the artifact which a provenance statement describes.
+type provenanceSubject struct {
+	Name   string            `json:"name"`
+	Digest map[string]string `json:"digest"`
+}
+
+// provenanceMaterial is an input which was used during a build, either a base
+// image or a Dockerfile.
+type provenanceMaterial struct {
+	URI    string            `json:"uri"`
+	Digest map[string]string `json:"digest,omitempty"`
+}
+
+type provenanceBuilder struct {
+	ID string `json:"id"`
+}
+
+type provenanceInvocation struct {
+	Parameters map[string]interface{} `json:"parameters,omitempty"`
+}
+
+type provenanceMetadata struct {
+	BuildStartedOn  *time.Time `json:"buildStartedOn,omitempty"`
+	BuildFinishedOn *time.Time `json:"buildFinishedOn,omitempty"`
+}
+
+type provenancePredicate struct {
+	Builder    provenanceBuilder    `json:"builder"`
+	BuildType  string               `json:"buildType"`
+	Invocation provenanceInvocation `json:"invocation"`
+	Metadata   provenanceMetadata   `json:"metadata"`
+	Materials  []provenanceMaterial `json:"materials,omitempty"`
+}
+
+type provenanceStatement struct {
+	Type          string              `json:"_type"`
+	Subject       []provenanceSubject `json:"subject"`
+	PredicateType string              `json:"predicateType"`
+	Predicate     provenancePredicate `json:"predicate"`
+}
+
+// digestMap converts a digest into the algorithm-to-hex-value map that in-toto
+// uses.
+func digestMap(d digest.Digest) map[string]string {
+	if d.Validate() != nil {
+		return nil
+	}
+	return map[string]string{d.Algorithm().String(): d.Hex()}
+}
+
+// recordBaseImage notes that we used the specified image as the base for a
+// stage, so that it can be listed among the materials of the build.
+func (b *Executor) recordBaseImage(name, imageID string) {
+	if b.provenanceFile == "" || imageID == "" {
+		return
+	}
+	for _, material := range b.provenanceMaterials {
+		if material.URI == name && material.Digest[digest.SHA256.String()] == imageID {
+			return
+		}
+	}
+	b.provenanceMaterials = append(b.provenanceMaterials, provenanceMaterial{
+		URI:    name,
+		Digest: digestMap(digest.NewDigestFromHex(digest.SHA256.String(), imageID)),
+	})
+}
+
+// writeProvenance writes an in-toto statement with a SLSA provenance predicate
+// describing the build which produced the image with the specified ID to the
+// file named by b.provenanceFile.
+func (b *Executor) writeProvenance(imageID string, started, finished time.Time) error {
+	subjectName := b.output
+	if subjectName == "" {
+		subjectName = imageID
+	}
+	parameters := map[string]interface{}{}
+	if len(b.buildArgs) > 0 {
+		parameters["buildArgs"] = b.buildArgs
+	}
+	if b.target != "" {
+		parameters["target"] = b.target
+	}
+	if len(b.labels) > 0 {
+		parameters["labels"] = b.labels
+	}
+	if len(b.annotations) > 0 {
+		parameters["annotations"] = b.annotations
+	}
+	if len(b.additionalTags) > 0 {
+		parameters["additionalTags"] = b.additionalTags
+	}
+	parameters["format"] = b.outputFormat
+	parameters["squash"] = b.squash
+	parameters["layers"] = b.layers
+	materials := append([]provenanceMaterial{}, b.provenanceMaterials...)
+	dockerfiles := make([]string, 0, len(b.dockerfileDigests))
+	for dockerfile := range b.dockerfileDigests {
+		dockerfiles = append(dockerfiles, dockerfile)
+	}
+	sort.Strings(dockerfiles)
+	for _, dockerfile := range dockerfiles {
+		materials = append(materials, provenanceMaterial{
+			URI:    dockerfile,
+			Digest: digestMap(b.dockerfileDigests[dockerfile]),
+		})
+	}
+	statement := provenanceStatement{
+		Type: InTotoStatementType,
+		Subject: []provenanceSubject{{
+			Name:   subjectName,
+			Digest: digestMap(digest.NewDigestFromHex(digest.SHA256.String(), imageID)),
+		}},
+		PredicateType: SLSAProvenancePredicateType,
+		Predicate: provenancePredicate{
+			Builder:   provenanceBuilder{ID: fmt.Sprintf("%s@%s", buildah.Package, buildah.Version)},
+			BuildType: provenanceBuildType,
+			Invocation: provenanceInvocation{
+				Parameters: parameters,
+			},
+			Metadata: provenanceMetadata{
+				BuildStartedOn:  &started,
+				BuildFinishedOn: &finished,
+			},
+			Materials: materials,
+		},
+	}
+	encoded, err := json.MarshalIndent(&statement, "", "  ")
+	if err != nil {
+		return errors.Wrapf(err, "error encoding provenance statement")
+	}
+	if err = ioutil.WriteFile(b.provenanceFile, encoded, 0644); err != nil {
+		return errors.Wrapf(err, "error writing provenance statement to %q", b.provenanceFile)
+	}
+	return nil
+}
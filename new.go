@@ -26,10 +26,13 @@ const (
 
 func pullAndFindImage(ctx context.Context, store storage.Store, srcRef types.ImageReference, options BuilderOptions, sc *types.SystemContext) (*storage.Image, types.ImageReference, error) {
 	pullOptions := PullOptions{
-		ReportWriter:  options.ReportWriter,
-		Store:         store,
-		SystemContext: options.SystemContext,
-		BlobDirectory: options.BlobDirectory,
+		ReportWriter:         options.ReportWriter,
+		Store:                store,
+		SystemContext:        options.SystemContext,
+		BlobDirectory:        options.BlobDirectory,
+		MaxParallelDownloads: options.MaxParallelDownloads,
+		Progress:             options.Progress,
+		ProgressInterval:     options.ProgressInterval,
 	}
 	ref, err := pullImage(ctx, store, srcRef, pullOptions, sc)
 	if err != nil {
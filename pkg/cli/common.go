@@ -49,26 +49,38 @@ type BudResults struct {
 	BuildArg            []string
 	CacheFrom           string
 	CertDir             string
+	ChownSource         string
 	Compress            bool
+	ContextDir          string
+	DestTLSVerify       bool
 	Creds               string
 	DisableCompression  bool
 	DisableContentTrust bool
+	EmbedContainerfile  string
 	File                []string
 	Format              string
 	Iidfile             string
 	Label               []string
+	LabelFromCmd        []string
 	Logfile             string
 	Loglevel            int
 	NoCache             bool
+	NoCacheStage        []string
 	Platform            string
+	PrintCacheKeys      bool
+	Provenance          string
 	Pull                bool
 	PullAlways          bool
 	Quiet               bool
+	RequireBuildArg     []string
 	Rm                  bool
 	Runtime             string
 	RuntimeFlags        []string
+	Shell               string
 	SignaturePolicy     string
+	SrcTLSVerify        bool
 	Squash              bool
+	SquashTo            int
 	Tag                 []string
 	Target              string
 	TlsVerify           bool
@@ -77,27 +89,30 @@ type BudResults struct {
 // FromAndBugResults represents the results for common flags
 // in bud and from
 type FromAndBudResults struct {
-	AddHost      []string
-	BlobCache    string
-	CapAdd       []string
-	CapDrop      []string
-	CgroupParent string
-	CPUPeriod    uint64
-	CPUQuota     int64
-	CPUSetCPUs   string
-	CPUSetMems   string
-	CPUShares    uint64
-	DNSSearch    []string
-	DNSServers   []string
-	DNSOptions   []string
-	HttpProxy    bool
-	Isolation    string
-	Memory       string
-	MemorySwap   string
-	SecurityOpt  []string
-	ShmSize      string
-	Ulimit       []string
-	Volumes      []string
+	AddHost              []string
+	BlobCache            string
+	CapAdd               []string
+	CapDrop              []string
+	CgroupParent         string
+	CPUPeriod            uint64
+	CPUQuota             int64
+	CPURealtimePeriod    uint64
+	CPURealtimeRuntime   int64
+	CPUSetCPUs           string
+	CPUSetMems           string
+	CPUShares            uint64
+	DNSSearch            []string
+	DNSServers           []string
+	DNSOptions           []string
+	HttpProxy            bool
+	Isolation            string
+	MaxParallelDownloads int
+	Memory               string
+	MemorySwap           string
+	SecurityOpt          []string
+	ShmSize              string
+	Ulimit               []string
+	Volumes              []string
 }
 
 // GetUserNSFlags returns the common flags for usernamespace
@@ -144,26 +159,38 @@ func GetBudFlags(flags *BudResults) pflag.FlagSet {
 	fs.StringArrayVar(&flags.BuildArg, "build-arg", []string{}, "`argument=value` to supply to the builder")
 	fs.StringVar(&flags.CacheFrom, "cache-from", "", "Images to utilise as potential cache sources. The build process does not currently support caching so this is a NOOP.")
 	fs.StringVar(&flags.CertDir, "cert-dir", "", "use certificates at the specified path to access the registry")
+	fs.StringVar(&flags.ChownSource, "chown-source", "target", "look up user and group names for COPY and ADD --chown in the container (`target`) or on the host (host)")
 	fs.BoolVar(&flags.Compress, "compress", false, "This is legacy option, which has no effect on the image")
+	fs.StringVar(&flags.ContextDir, "context-dir", "", "use `directory or URL` as the build context instead of the first argument")
 	fs.StringVar(&flags.Creds, "creds", "", "use `[username[:password]]` for accessing the registry")
+	fs.BoolVar(&flags.DestTLSVerify, "dest-tls-verify", true, "require HTTPS and verify certificates when writing the built image to a registry (overrides --tls-verify)")
 	fs.BoolVarP(&flags.DisableCompression, "disable-compression", "D", true, "don't compress layers by default")
 	fs.BoolVar(&flags.DisableContentTrust, "disable-content-trust", false, "This is a Docker specific option and is a NOOP")
+	fs.StringVar(&flags.EmbedContainerfile, "embed-containerfile", "", "store the Dockerfile in the image, either as a label (`label`) or as a file at the specified absolute path")
 	fs.StringSliceVarP(&flags.File, "file", "f", []string{}, "`pathname or URL` of a Dockerfile")
 	fs.StringVar(&flags.Format, "format", DefaultFormat(), "`format` of the built image's manifest and metadata. Use BUILDAH_FORMAT environment variable to override.")
 	fs.StringVar(&flags.Iidfile, "iidfile", "", "`file` to write the image ID to")
 	fs.StringArrayVar(&flags.Label, "label", []string{}, "Set metadata for an image (default [])")
+	fs.StringArrayVar(&flags.LabelFromCmd, "label-from-cmd", []string{}, "set an image label to the output of a command (`name=command [args...]`)")
 	fs.BoolVar(&flags.NoCache, "no-cache", false, "Do not use existing cached images for the container build. Build from the start with a new set of cached layers.")
+	fs.StringArrayVar(&flags.NoCacheStage, "no-cache-stage", []string{}, "do not use existing cached images when building the named `stage` (default [])")
 	fs.StringVar(&flags.Logfile, "logfile", "", "log to `file` instead of stdout/stderr")
 	fs.IntVar(&flags.Loglevel, "loglevel", 0, "adjust logging level (range from -2 to 3)")
 	fs.StringVar(&flags.Platform, "platform", "", "CLI compatibility: no action or effect")
+	fs.BoolVar(&flags.PrintCacheKeys, "print-cache-keys", false, "print the cache key computed for each instruction, and whether or not a cached image was found for it, when using --layers")
+	fs.StringVar(&flags.Provenance, "provenance", "", "write an in-toto SLSA provenance statement describing the build to `file`")
 	fs.BoolVar(&flags.Pull, "pull", true, "pull the image if not present")
 	fs.BoolVar(&flags.PullAlways, "pull-always", false, "pull the image, even if a version is present")
 	fs.BoolVarP(&flags.Quiet, "quiet", "q", false, "refrain from announcing build instructions and image read/write progress")
+	fs.StringArrayVar(&flags.RequireBuildArg, "require-build-arg", []string{}, "fail if build `argument` is given no value by --build-arg or by a default in the Dockerfile (default [])")
 	fs.BoolVar(&flags.Rm, "rm", true, "Remove intermediate containers after a successful build")
 	fs.StringVar(&flags.Runtime, "runtime", util.Runtime(), "`path` to an alternate runtime. Use BUILDAH_RUNTIME environment variable to override.")
 	fs.StringSliceVar(&flags.RuntimeFlags, "runtime-flag", []string{}, "add global flags for the container runtime")
+	fs.StringVar(&flags.Shell, "shell", "", "default `shell` to use for shell-form RUN instructions in stages which don't use a SHELL instruction (default \"/bin/sh -c\")")
 	fs.StringVar(&flags.SignaturePolicy, "signature-policy", "", "`pathname` of signature policy file (not usually used)")
 	fs.BoolVar(&flags.Squash, "squash", false, "Squash newly built layers into a single new layer.")
+	fs.IntVar(&flags.SquashTo, "squash-to", 0, "merge the layers added to the final stage's base image until no more than `number` remain")
+	fs.BoolVar(&flags.SrcTLSVerify, "src-tls-verify", true, "require HTTPS and verify certificates when pulling base images from a registry (overrides --tls-verify)")
 	fs.StringArrayVarP(&flags.Tag, "tag", "t", []string{}, "tagged `name` to apply to the built image")
 	fs.StringVar(&flags.Target, "target", "", "set the target build stage to build")
 	fs.BoolVar(&flags.TlsVerify, "tls-verify", true, "require HTTPS and verify certificates when accessing the registry")
@@ -182,6 +209,8 @@ func GetFromAndBudFlags(flags *FromAndBudResults, usernsResults *UserNSResults,
 	fs.StringVar(&flags.CgroupParent, "cgroup-parent", "", "optional parent cgroup for the container")
 	fs.Uint64Var(&flags.CPUPeriod, "cpu-period", 0, "limit the CPU CFS (Completely Fair Scheduler) period")
 	fs.Int64Var(&flags.CPUQuota, "cpu-quota", 0, "limit the CPU CFS (Completely Fair Scheduler) quota")
+	fs.Uint64Var(&flags.CPURealtimePeriod, "cpu-rt-period", 0, "limit the CPU real-time period in microseconds")
+	fs.Int64Var(&flags.CPURealtimeRuntime, "cpu-rt-runtime", 0, "limit the CPU real-time runtime in microseconds")
 	fs.Uint64VarP(&flags.CPUShares, "cpu-shares", "c", 0, "CPU shares (relative weight)")
 	fs.StringVar(&flags.CPUSetCPUs, "cpuset-cpus", "", "CPUs in which to allow execution (0-3, 0,1)")
 	fs.StringVar(&flags.CPUSetMems, "cpuset-mems", "", "memory nodes (MEMs) in which to allow execution (0-3, 0,1). Only effective on NUMA systems.")
@@ -190,6 +219,7 @@ func GetFromAndBudFlags(flags *FromAndBudResults, usernsResults *UserNSResults,
 	fs.StringSliceVar(&flags.DNSOptions, "dns-option", []string{}, "Set custom DNS options")
 	fs.BoolVar(&flags.HttpProxy, "http-proxy", true, "pass thru HTTP Proxy environment variables")
 	fs.StringVar(&flags.Isolation, "isolation", DefaultIsolation(), "`type` of process isolation to use. Use BUILDAH_ISOLATION environment variable to override.")
+	fs.IntVar(&flags.MaxParallelDownloads, "max-parallel-downloads", 0, "maximum number of layers to download at the same time (default is the image library's built-in limit)")
 	fs.StringVarP(&flags.Memory, "memory", "m", "", "memory limit (format: <number>[<unit>], where unit = b, k, m or g)")
 	fs.StringVar(&flags.MemorySwap, "memory-swap", "", "swap limit equal to memory plus swap: '-1' to enable unlimited swap")
 	fs.StringArrayVar(&flags.SecurityOpt, "security-opt", []string{}, "security options (default [])")
@@ -0,0 +1,99 @@
+package parse
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// AuthFileFromEnvPrefix is the prefix which, when used at the start of the
+// value of the --authfile flag, indicates that the rest of the value is the
+// name of an environment variable which contains the contents of an
+// authentication file, rather than the location of one.
+const AuthFileFromEnvPrefix = "env:"
+
+// authConfigFile is the subset of the authentication file's format that we
+// need to understand in order to merge two of them.  The per-registry entries
+// are kept as they are.
+type authConfigFile struct {
+	AuthConfigs map[string]json.RawMessage `json:"auths"`
+	CredHelpers map[string]string          `json:"credHelpers,omitempty"`
+}
+
+// defaultAuthFilePath returns the location of the authentication file that
+// would be used if none was specified.
+func defaultAuthFilePath() string {
+	if authfile := getAuthFile(""); authfile != "" && !strings.HasPrefix(authfile, AuthFileFromEnvPrefix) {
+		return authfile
+	}
+	if runtimeDir := os.Getenv("XDG_RUNTIME_DIR"); runtimeDir != "" {
+		return filepath.Join(runtimeDir, "containers", "auth.json")
+	}
+	return fmt.Sprintf("/run/containers/%d/auth.json", os.Getuid())
+}
+
+// readAuthConfigFile parses the contents of an authentication file.
+func readAuthConfigFile(contents []byte) (*authConfigFile, error) {
+	var auths authConfigFile
+	if err := json.Unmarshal(contents, &auths); err != nil {
+		return nil, err
+	}
+	if auths.AuthConfigs == nil {
+		auths.AuthConfigs = make(map[string]json.RawMessage)
+	}
+	if auths.CredHelpers == nil {
+		auths.CredHelpers = make(map[string]string)
+	}
+	return &auths, nil
+}
+
+// authFileFromEnv reads the contents of an authentication file from the named
+// environment variable, merges them with the contents of the file at basePath,
+// if there is one, giving precedence to the values from the environment, and
+// returns the location of a file containing the result which can be used as
+// the AuthFilePath in a SystemContext.
+func authFileFromEnv(name, basePath string) (string, error) {
+	if name == "" {
+		return "", errors.Errorf("no environment variable name specified after %q", AuthFileFromEnvPrefix)
+	}
+	value, ok := os.LookupEnv(name)
+	if !ok || value == "" {
+		return "", errors.Errorf("environment variable %q, which should contain authentication information, is not set", name)
+	}
+	auths, err := readAuthConfigFile([]byte(value))
+	if err != nil {
+		return "", errors.Wrapf(err, "error parsing authentication information in environment variable %q", name)
+	}
+	if basePath != "" {
+		contents, err := ioutil.ReadFile(basePath)
+		if err != nil && !os.IsNotExist(err) {
+			return "", errors.Wrapf(err, "error reading authentication file %q", basePath)
+		}
+		if err == nil {
+			base, err := readAuthConfigFile(contents)
+			if err != nil {
+				return "", errors.Wrapf(err, "error parsing authentication file %q", basePath)
+			}
+			for registry, auth := range base.AuthConfigs {
+				if _, ok := auths.AuthConfigs[registry]; !ok {
+					auths.AuthConfigs[registry] = auth
+				}
+			}
+			for registry, helper := range base.CredHelpers {
+				if _, ok := auths.CredHelpers[registry]; !ok {
+					auths.CredHelpers[registry] = helper
+				}
+			}
+		}
+	}
+	merged, err := json.Marshal(auths)
+	if err != nil {
+		return "", errors.Wrapf(err, "error encoding authentication information")
+	}
+	return authFileFromMemory(merged)
+}
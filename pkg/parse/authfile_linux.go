@@ -0,0 +1,32 @@
+package parse
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/pkg/errors"
+	"golang.org/x/sys/unix"
+)
+
+// authFileFromMemory stores contents in an anonymous file which is never
+// written to disk, and returns a path which can be used to read it for as
+// long as this process is running.
+func authFileFromMemory(contents []byte) (string, error) {
+	fd, err := unix.MemfdCreate("buildah-auth", unix.MFD_CLOEXEC)
+	if err != nil {
+		return "", errors.Wrapf(err, "error creating in-memory authentication file")
+	}
+	f := os.NewFile(uintptr(fd), "buildah-auth")
+	if _, err = f.Write(contents); err != nil {
+		f.Close()
+		return "", errors.Wrapf(err, "error writing in-memory authentication file")
+	}
+	// Leave the descriptor open: each open() of its /proc path starts
+	// reading from the beginning of the file.
+	authFiles = append(authFiles, f)
+	return fmt.Sprintf("/proc/self/fd/%d", fd), nil
+}
+
+// authFiles keeps the in-memory authentication files that we've created from
+// being closed when they're garbage collected.
+var authFiles []*os.File
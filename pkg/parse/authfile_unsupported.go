@@ -0,0 +1,9 @@
+// +build !linux
+
+package parse
+
+import "github.com/pkg/errors"
+
+func authFileFromMemory(contents []byte) (string, error) {
+	return "", errors.Errorf("reading authentication information from the environment is not supported on this platform")
+}
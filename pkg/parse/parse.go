@@ -8,6 +8,7 @@ import (
 	"fmt"
 	"net"
 	"os"
+	"os/exec"
 	"path/filepath"
 	"strconv"
 	"strings"
@@ -109,26 +110,30 @@ func CommonBuildOptions(c *cobra.Command) (*buildah.CommonBuildOptions, error) {
 	}
 	cpuPeriod, _ := c.Flags().GetUint64("cpu-period")
 	cpuQuota, _ := c.Flags().GetInt64("cpu-quota")
+	cpuRealtimePeriod, _ := c.Flags().GetUint64("cpu-rt-period")
+	cpuRealtimeRuntime, _ := c.Flags().GetInt64("cpu-rt-runtime")
 	cpuShares, _ := c.Flags().GetUint64("cpu-shares")
 	httpProxy, _ := c.Flags().GetBool("http-proxy")
 	ulimit, _ := c.Flags().GetStringSlice("ulimit")
 	commonOpts := &buildah.CommonBuildOptions{
-		AddHost:      addHost,
-		CgroupParent: c.Flag("cgroup-parent").Value.String(),
-		CPUPeriod:    cpuPeriod,
-		CPUQuota:     cpuQuota,
-		CPUSetCPUs:   c.Flag("cpuset-cpus").Value.String(),
-		CPUSetMems:   c.Flag("cpuset-mems").Value.String(),
-		CPUShares:    cpuShares,
-		DNSSearch:    dnsSearch,
-		DNSServers:   dnsServers,
-		DNSOptions:   dnsOptions,
-		HTTPProxy:    httpProxy,
-		Memory:       memoryLimit,
-		MemorySwap:   memorySwap,
-		ShmSize:      c.Flag("shm-size").Value.String(),
-		Ulimit:       append(defaultLimits, ulimit...),
-		Volumes:      volumes,
+		AddHost:            addHost,
+		CgroupParent:       c.Flag("cgroup-parent").Value.String(),
+		CPUPeriod:          cpuPeriod,
+		CPUQuota:           cpuQuota,
+		CPURealtimePeriod:  cpuRealtimePeriod,
+		CPURealtimeRuntime: cpuRealtimeRuntime,
+		CPUSetCPUs:         c.Flag("cpuset-cpus").Value.String(),
+		CPUSetMems:         c.Flag("cpuset-mems").Value.String(),
+		CPUShares:          cpuShares,
+		DNSSearch:          dnsSearch,
+		DNSServers:         dnsServers,
+		DNSOptions:         dnsOptions,
+		HTTPProxy:          httpProxy,
+		Memory:             memoryLimit,
+		MemorySwap:         memorySwap,
+		ShmSize:            c.Flag("shm-size").Value.String(),
+		Ulimit:             append(defaultLimits, ulimit...),
+		Volumes:            volumes,
 	}
 	securityOpts, _ := c.Flags().GetStringArray("security-opt")
 	if err := parseSecurityOpts(securityOpts, commonOpts); err != nil {
@@ -330,7 +335,7 @@ func GetBindMount(args []string) (specs.Mount, error) {
 			// TODO: detect duplication of these options.
 			// (Is this necessary?)
 			newMount.Options = append(newMount.Options, kv[0])
-		case "shared", "rshared", "private", "rprivate", "slave", "rslave", "Z", "z":
+		case "shared", "rshared", "private", "rprivate", "slave", "rslave", "Z", "z", "U":
 			newMount.Options = append(newMount.Options, kv[0])
 		case "bind-propagation":
 			if len(kv) == 1 {
@@ -463,7 +468,7 @@ func ValidateVolumeCtrDir(ctrDir string) error {
 
 // ValidateVolumeOpts validates a volume's options
 func ValidateVolumeOpts(options []string) ([]string, error) {
-	var foundRootPropagation, foundRWRO, foundLabelChange, bindType int
+	var foundRootPropagation, foundRWRO, foundLabelChange, bindType, foundChown int
 	finalOpts := make([]string, 0, len(options))
 	for _, opt := range options {
 		switch opt {
@@ -485,6 +490,11 @@ func ValidateVolumeOpts(options []string) ([]string, error) {
 				return nil, errors.Errorf("invalid options %q, can only specify 1 '[r]shared', '[r]private', '[r]slave' or '[r]unbindable' option", strings.Join(options, ", "))
 			}
 			foundRootPropagation++
+		case "U":
+			if foundChown > 0 {
+				return nil, errors.Errorf("invalid options %q, can only specify 1 'U' option", strings.Join(options, ", "))
+			}
+			foundChown++
 		case "bind", "rbind":
 			bindType++
 			if bindType > 1 {
@@ -543,9 +553,7 @@ func SystemContextFromOptions(c *cobra.Command) (*types.SystemContext, error) {
 	}
 	tlsVerify, err := c.Flags().GetBool("tls-verify")
 	if err == nil && c.Flag("tls-verify").Changed {
-		ctx.DockerInsecureSkipTLSVerify = types.NewOptionalBool(!tlsVerify)
-		ctx.OCIInsecureSkipTLSVerify = !tlsVerify
-		ctx.DockerDaemonInsecureSkipTLSVerify = !tlsVerify
+		setTLSVerify(ctx, tlsVerify)
 	}
 	creds, err := c.Flags().GetString("creds")
 	if err == nil && c.Flag("creds").Changed {
@@ -562,6 +570,12 @@ func SystemContextFromOptions(c *cobra.Command) (*types.SystemContext, error) {
 	authfile, err := c.Flags().GetString("authfile")
 	if err == nil {
 		ctx.AuthFilePath = getAuthFile(authfile)
+		if strings.HasPrefix(ctx.AuthFilePath, AuthFileFromEnvPrefix) {
+			ctx.AuthFilePath, err = authFileFromEnv(strings.TrimPrefix(ctx.AuthFilePath, AuthFileFromEnvPrefix), defaultAuthFilePath())
+			if err != nil {
+				return nil, err
+			}
+		}
 	}
 	regConf, err := c.Flags().GetString("registries-conf")
 	if err == nil && c.Flag("registries-conf").Changed {
@@ -575,6 +589,36 @@ func SystemContextFromOptions(c *cobra.Command) (*types.SystemContext, error) {
 	return ctx, nil
 }
 
+// SourceAndDestinationSystemContextsFromOptions returns one SystemContext to
+// be used when reading images and another to be used when writing them.  They
+// are built in the same way as the one returned by SystemContextFromOptions,
+// except that the --src-tls-verify and --dest-tls-verify flags, if the command
+// has them and they were set, override --tls-verify for the corresponding
+// SystemContext.
+func SourceAndDestinationSystemContextsFromOptions(c *cobra.Command) (*types.SystemContext, *types.SystemContext, error) {
+	src, err := SystemContextFromOptions(c)
+	if err != nil {
+		return nil, nil, err
+	}
+	dest := &types.SystemContext{}
+	*dest = *src
+	srcTLSVerify, err := c.Flags().GetBool("src-tls-verify")
+	if err == nil && c.Flag("src-tls-verify").Changed {
+		setTLSVerify(src, srcTLSVerify)
+	}
+	destTLSVerify, err := c.Flags().GetBool("dest-tls-verify")
+	if err == nil && c.Flag("dest-tls-verify").Changed {
+		setTLSVerify(dest, destTLSVerify)
+	}
+	return src, dest, nil
+}
+
+func setTLSVerify(ctx *types.SystemContext, tlsVerify bool) {
+	ctx.DockerInsecureSkipTLSVerify = types.NewOptionalBool(!tlsVerify)
+	ctx.OCIInsecureSkipTLSVerify = !tlsVerify
+	ctx.DockerDaemonInsecureSkipTLSVerify = !tlsVerify
+}
+
 func getAuthFile(authfile string) string {
 	if authfile != "" {
 		return authfile
@@ -875,3 +919,32 @@ func RegistryFromFullName(input string) string {
 	}
 	return split[0]
 }
+
+// LabelsFromCommands runs the commands described by a list of
+// "name=command [arg ...]" specifications and returns a list of "name=value"
+// label specifications, where each value is the output of the corresponding
+// command with leading and trailing whitespace removed.  The commands are run
+// directly, without a shell, so that no interpolation of their arguments
+// takes place.  An error is returned if any of the commands fails.
+func LabelsFromCommands(specs []string) ([]string, error) {
+	labels := make([]string, 0, len(specs))
+	for _, spec := range specs {
+		nameAndCommand := strings.SplitN(spec, "=", 2)
+		if len(nameAndCommand) != 2 || nameAndCommand[0] == "" {
+			return nil, errors.Errorf("invalid label-from-cmd %q: expected name=command", spec)
+		}
+		name := nameAndCommand[0]
+		args := strings.Fields(nameAndCommand[1])
+		if len(args) == 0 {
+			return nil, errors.Errorf("invalid label-from-cmd %q: no command specified", spec)
+		}
+		cmd := exec.Command(args[0], args[1:]...)
+		cmd.Stderr = os.Stderr
+		output, err := cmd.Output()
+		if err != nil {
+			return nil, errors.Wrapf(err, "error running %q to compute the value of label %q", nameAndCommand[1], name)
+		}
+		labels = append(labels, name+"="+strings.TrimSpace(string(output)))
+	}
+	return labels, nil
+}
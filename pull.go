@@ -2,9 +2,10 @@ package buildah
 
 import (
 	"context"
+	"fmt"
 	"io"
-
 	"strings"
+	"time"
 
 	"github.com/containers/buildah/pkg/blobcache"
 	"github.com/containers/buildah/util"
@@ -49,6 +50,18 @@ type PullOptions struct {
 	// AllTags is a boolean value that determines if all tagged images
 	// will be downloaded from the repository. The default is false.
 	AllTags bool
+	// MaxParallelDownloads is the maximum number of layer blobs which
+	// will be downloaded at the same time.  If it is not set, the
+	// default limit which is built into the image library is used.
+	MaxParallelDownloads int
+	// Progress, if set, is called with reports of how much of each blob
+	// has been downloaded, no more often than once per ProgressInterval,
+	// or DefaultProgressInterval if that is not set.  The report for a
+	// blob's final bytes may be skipped.
+	Progress ProgressFunc
+	// ProgressInterval is the minimum amount of time between calls to
+	// Progress for a single blob.
+	ProgressInterval time.Duration
 }
 
 func localImageNameForReference(ctx context.Context, store storage.Store, srcRef types.ImageReference) (string, error) {
@@ -162,6 +175,8 @@ func Pull(ctx context.Context, imageName string, options PullOptions) (imageID s
 		SystemContext:       systemContext,
 		BlobDirectory:       options.BlobDirectory,
 		ReportWriter:        options.ReportWriter,
+		Progress:            options.Progress,
+		ProgressInterval:    options.ProgressInterval,
 	}
 
 	storageRef, transport, img, err := resolveImage(ctx, systemContext, options.Store, boptions)
@@ -184,32 +199,35 @@ func Pull(ctx context.Context, imageName string, options PullOptions) (imageID s
 		if err != nil {
 			return "", errors.Wrapf(err, "error getting repository tags")
 		}
-		for _, tag := range tags {
+		var failed []string
+		for i, tag := range tags {
 			tagged, err := reference.WithTag(repo, tag)
 			if err != nil {
-				errs = multierror.Append(errs, err)
+				errs = multierror.Append(errs, errors.Wrapf(err, "error tagging %q with %q", repo.String(), tag))
+				failed = append(failed, tag)
 				continue
 			}
-			taggedRef, err := docker.NewReference(tagged)
-			if err != nil {
-				return "", errors.Wrapf(err, "internal error creating docker.Transport reference for %s", tagged.String())
-			}
 			if options.ReportWriter != nil {
-				if _, err := options.ReportWriter.Write([]byte("Pulling " + tagged.String() + "\n")); err != nil {
+				if _, err := fmt.Fprintf(options.ReportWriter, "Pulling %s (%d of %d)\n", tagged.String(), i+1, len(tags)); err != nil {
 					return "", errors.Wrapf(err, "error writing pull report")
 				}
 			}
-			ref, err := pullImage(ctx, options.Store, taggedRef, options, systemContext)
+			taggedImageID, err := pullTag(ctx, options.Store, tagged, options, systemContext)
 			if err != nil {
-				errs = multierror.Append(errs, err)
+				errs = multierror.Append(errs, errors.Wrapf(err, "error pulling %q", tagged.String()))
+				failed = append(failed, tag)
 				continue
 			}
-			taggedImg, err := is.Transport.GetStoreImage(options.Store, ref)
-			if err != nil {
-				errs = multierror.Append(errs, err)
-				continue
+			imageID = taggedImageID
+		}
+		if options.ReportWriter != nil {
+			summary := fmt.Sprintf("Pulled %d of %d tags from %s\n", len(tags)-len(failed), len(tags), repo.String())
+			if len(failed) > 0 {
+				summary += fmt.Sprintf("Failed to pull tags: %s\n", strings.Join(failed, ", "))
+			}
+			if _, err := io.WriteString(options.ReportWriter, summary); err != nil {
+				return "", errors.Wrapf(err, "error writing pull report")
 			}
-			imageID = taggedImg.ID
 		}
 	} else {
 		imageID = img.ID
@@ -218,6 +236,24 @@ func Pull(ctx context.Context, imageName string, options PullOptions) (imageID s
 	return imageID, errs.ErrorOrNil()
 }
 
+// pullTag pulls a single tagged image from a registry into local storage and
+// returns its ID.
+func pullTag(ctx context.Context, store storage.Store, tagged reference.NamedTagged, options PullOptions, sc *types.SystemContext) (string, error) {
+	taggedRef, err := docker.NewReference(tagged)
+	if err != nil {
+		return "", errors.Wrapf(err, "internal error creating docker.Transport reference for %s", tagged.String())
+	}
+	ref, err := pullImage(ctx, store, taggedRef, options, sc)
+	if err != nil {
+		return "", err
+	}
+	taggedImg, err := is.Transport.GetStoreImage(store, ref)
+	if err != nil {
+		return "", errors.Wrapf(err, "error locating pulled image %q", tagged.String())
+	}
+	return taggedImg.ID, nil
+}
+
 func pullImage(ctx context.Context, store storage.Store, srcRef types.ImageReference, options PullOptions, sc *types.SystemContext) (types.ImageReference, error) {
 	blocked, err := isReferenceBlocked(srcRef, sc)
 	if err != nil {
@@ -264,8 +300,14 @@ func pullImage(ctx context.Context, store storage.Store, srcRef types.ImageRefer
 		}
 	}()
 
+	maybeLimitedSrcRef := newLimitedReference(srcRef, options.MaxParallelDownloads)
+
 	logrus.Debugf("copying %q to %q", transports.ImageName(srcRef), destName)
-	if _, err := cp.Image(ctx, policyContext, maybeCachedDestRef, srcRef, getCopyOptions(store, options.ReportWriter, srcRef, sc, maybeCachedDestRef, nil, "")); err != nil {
+	copyOptions := getCopyOptions(store, options.ReportWriter, maybeLimitedSrcRef, sc, maybeCachedDestRef, nil, "")
+	stopProgress := addProgress(copyOptions, options.Progress, options.ProgressInterval)
+	_, err = cp.Image(ctx, policyContext, maybeCachedDestRef, maybeLimitedSrcRef, copyOptions)
+	stopProgress()
+	if err != nil {
 		logrus.Debugf("error copying src image [%q] to dest image [%q] err: %v", transports.ImageName(srcRef), destName, err)
 		return nil, err
 	}
@@ -167,6 +167,10 @@ type RunOptions struct {
 	// after processing the AddCapabilities set.  If a capability appears in both
 	// lists, it will be dropped.
 	DropCapabilities []string
+	// CPURealtimePeriod and CPURealtimeRuntime, if not zero, override the
+	// corresponding values in the container's CommonBuildOpts.
+	CPURealtimePeriod  uint64
+	CPURealtimeRuntime int64
 }
 
 // Find the configuration for the namespace of the given type.  If there are
@@ -1,3 +1,4 @@
+//go:build linux
 // +build linux
 
 package buildah
@@ -12,6 +13,7 @@ import (
 	"net"
 	"os"
 	"os/exec"
+	"os/signal"
 	"path/filepath"
 	"runtime"
 	"strconv"
@@ -44,6 +46,10 @@ import (
 	"golang.org/x/sys/unix"
 )
 
+// realtimeRuntimeCgroupFile is present if the kernel and the cgroup hierarchy
+// allow limits on realtime scheduling to be set for containers.
+const realtimeRuntimeCgroupFile = "/sys/fs/cgroup/cpu/cpu.rt_runtime_us"
+
 func setChildProcess() error {
 	if err := unix.Prctl(unix.PR_SET_CHILD_SUBREAPER, uintptr(1), 0, 0, 0); err != nil {
 		fmt.Fprintf(os.Stderr, "prctl(PR_SET_CHILD_SUBREAPER, 1): %v\n", err)
@@ -84,7 +90,7 @@ func (b *Builder) Run(command []string, options RunOptions) error {
 			isolation = IsolationOCI
 		}
 	}
-	if err := checkAndOverrideIsolationOptions(isolation, &options); err != nil {
+	if err := checkAndOverrideIsolationOptions(isolation, b.NamespaceOptions, &options); err != nil {
 		return err
 	}
 
@@ -94,7 +100,14 @@ func (b *Builder) Run(command []string, options RunOptions) error {
 		return errors.Errorf("Invalid format on container you must recreate the container")
 	}
 
-	if err := addCommonOptsToSpec(b.CommonBuildOpts, g); err != nil {
+	commonOpts := *b.CommonBuildOpts
+	if options.CPURealtimePeriod != 0 {
+		commonOpts.CPURealtimePeriod = options.CPURealtimePeriod
+	}
+	if options.CPURealtimeRuntime != 0 {
+		commonOpts.CPURealtimeRuntime = options.CPURealtimeRuntime
+	}
+	if err := addCommonOptsToSpec(&commonOpts, g); err != nil {
 		return err
 	}
 
@@ -244,6 +257,18 @@ func addCommonOptsToSpec(commonOpts *CommonBuildOptions, g *generate.Generator)
 	if commonOpts.CPUSetMems != "" {
 		g.SetLinuxResourcesCPUMems(commonOpts.CPUSetMems)
 	}
+	if commonOpts.CPURealtimePeriod != 0 || commonOpts.CPURealtimeRuntime != 0 {
+		if _, err := os.Stat(realtimeRuntimeCgroupFile); err != nil {
+			logrus.Warnf("realtime CPU scheduling limits are not supported by this kernel or cgroup configuration (%v), ignoring them", err)
+		} else {
+			if commonOpts.CPURealtimePeriod != 0 {
+				g.SetLinuxResourcesCPURealtimePeriod(commonOpts.CPURealtimePeriod)
+			}
+			if commonOpts.CPURealtimeRuntime != 0 {
+				g.SetLinuxResourcesCPURealtimeRuntime(commonOpts.CPURealtimeRuntime)
+			}
+		}
+	}
 
 	// Resources - Memory
 	if commonOpts.Memory != 0 {
@@ -440,8 +465,15 @@ func (b *Builder) setupMounts(mountPoint string, spec *specs.Spec, bundlePath st
 		return err
 	}
 
+	// Figure out which UID and GID the process will run as, in case we
+	// need to give it ownership of the contents of any volumes.
+	processUID, processGID, err := util.GetHostIDs(spec.Linux.UIDMappings, spec.Linux.GIDMappings, spec.Process.User.UID, spec.Process.User.GID)
+	if err != nil {
+		return err
+	}
+
 	// Get the list of explicitly-specified volume mounts.
-	volumes, err := b.runSetupVolumeMounts(spec.Linux.MountLabel, volumeMounts, optionMounts, int(rootUID), int(rootGID))
+	volumes, err := b.runSetupVolumeMounts(spec.Linux.MountLabel, volumeMounts, optionMounts, int(rootUID), int(rootGID), int(processUID), int(processGID))
 	if err != nil {
 		return err
 	}
@@ -784,6 +816,26 @@ func runUsingRuntime(isolation Isolation, options RunOptions, configureNetwork b
 	if err != nil {
 		return 1, errors.Wrapf(err, "error starting container")
 	}
+	// If we're told to stop, stop the container, and let the loop below
+	// notice that it's stopped so that we can clean up after it.
+	interrupted := make(chan os.Signal, 100)
+	go func() {
+		for receivedSignal := range interrupted {
+			logrus.Debugf("received %v, stopping container %q", receivedSignal, containerName)
+			stop := exec.Command(runtime, append(append([]string{}, options.Args...), "kill", containerName, "KILL")...)
+			stop.Dir = bundlePath
+			stop.Stderr = os.Stderr
+			if err := stop.Run(); err != nil {
+				logrus.Errorf("%v while stopping container %q", err, containerName)
+			}
+		}
+	}()
+	signal.Notify(interrupted, syscall.SIGHUP, syscall.SIGINT, syscall.SIGTERM)
+	defer func() {
+		signal.Stop(interrupted)
+		close(interrupted)
+	}()
+
 	stopped := false
 	defer func() {
 		if !stopped {
@@ -1597,7 +1649,7 @@ func (b *Builder) cleanupTempVolumes() {
 	}
 }
 
-func (b *Builder) runSetupVolumeMounts(mountLabel string, volumeMounts []string, optionMounts []specs.Mount, rootUID, rootGID int) (mounts []specs.Mount, Err error) {
+func (b *Builder) runSetupVolumeMounts(mountLabel string, volumeMounts []string, optionMounts []specs.Mount, rootUID, rootGID, processUID, processGID int) (mounts []specs.Mount, Err error) {
 
 	// Make sure the overlay directory is clean before running
 	containerDir, err := b.store.ContainerDirectory(b.ContainerID)
@@ -1609,9 +1661,16 @@ func (b *Builder) runSetupVolumeMounts(mountLabel string, volumeMounts []string,
 	}
 
 	parseMount := func(mountType, host, container string, options []string) (specs.Mount, error) {
-		var foundrw, foundro, foundz, foundZ, foundO bool
+		var foundrw, foundro, foundz, foundZ, foundO, foundU bool
 		var rootProp string
+		mountOptions := make([]string, 0, len(options))
 		for _, opt := range options {
+			if opt == "U" {
+				// This is for us, not the runtime.
+				foundU = true
+				continue
+			}
+			mountOptions = append(mountOptions, opt)
 			switch opt {
 			case "rw":
 				foundrw = true
@@ -1627,6 +1686,7 @@ func (b *Builder) runSetupVolumeMounts(mountLabel string, volumeMounts []string,
 				rootProp = opt
 			}
 		}
+		options = mountOptions
 		if !foundrw && !foundro {
 			options = append(options, "rw")
 		}
@@ -1640,6 +1700,11 @@ func (b *Builder) runSetupVolumeMounts(mountLabel string, volumeMounts []string,
 				return specs.Mount{}, errors.Wrapf(err, "relabeling %q failed", host)
 			}
 		}
+		if foundU && mountType != "tmpfs" {
+			if err := chownVolume(host, processUID, processGID); err != nil {
+				return specs.Mount{}, err
+			}
+		}
 		if foundO {
 			overlayMount, contentDir, err := overlay.MountTemp(b.store, b.ContainerID, host, container, rootUID, rootGID)
 			if err == nil {
@@ -1689,6 +1754,19 @@ func (b *Builder) runSetupVolumeMounts(mountLabel string, volumeMounts []string,
 	return mounts, nil
 }
 
+// chownVolume recursively changes the ownership of the contents of a volume's
+// source location on the host to the specified IDs.
+func chownVolume(host string, uid, gid int) error {
+	logrus.Debugf("changing ownership of %q and its contents to %d:%d", host, uid, gid)
+	err := filepath.Walk(host, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		return os.Lchown(path, uid, gid)
+	})
+	return errors.Wrapf(err, "error changing ownership of %q to %d:%d", host, uid, gid)
+}
+
 func setupMaskedPaths(g *generate.Generator) {
 	for _, mp := range []string{
 		"/proc/acpi",
@@ -2007,7 +2085,23 @@ func (b *Builder) runUsingRuntimeSubproc(isolation Isolation, options RunOptions
 	cmd.ExtraFiles = append([]*os.File{preader}, cmd.ExtraFiles...)
 	defer preader.Close()
 	defer pwriter.Close()
-	err = cmd.Run()
+	if err = cmd.Start(); err != nil {
+		return errors.Wrapf(err, "error while starting runtime")
+	}
+	// Pass along termination signals to the child process, which will
+	// stop the container and clean up after it.
+	interrupted := make(chan os.Signal, 100)
+	go func() {
+		for receivedSignal := range interrupted {
+			if err := cmd.Process.Signal(receivedSignal); err != nil {
+				logrus.Infof("%v while attempting to forward %v to child process", err, receivedSignal)
+			}
+		}
+	}()
+	signal.Notify(interrupted, syscall.SIGHUP, syscall.SIGINT, syscall.SIGTERM)
+	err = cmd.Wait()
+	signal.Stop(interrupted)
+	close(interrupted)
 	if err != nil {
 		err = errors.Wrapf(err, "error while running runtime")
 	}
@@ -2021,7 +2115,16 @@ func (b *Builder) runUsingRuntimeSubproc(isolation Isolation, options RunOptions
 	return err
 }
 
-func checkAndOverrideIsolationOptions(isolation Isolation, options *RunOptions) error {
+func checkAndOverrideIsolationOptions(isolation Isolation, builderNamespaceOptions NamespaceOptions, options *RunOptions) error {
+	// Sharing the host's PID namespace means using its /proc, which we
+	// can't mount from inside of a user namespace that we created, so
+	// refuse to try if we were explicitly asked to do that.
+	requested := append(append(NamespaceOptions{}, builderNamespaceOptions...), options.NamespaceOptions...)
+	if pidns := requested.Find(string(specs.PIDNamespace)); pidns != nil && pidns.Host {
+		if isolation == IsolationOCIRootless || (isolation == IsolationOCI && unshare.IsRootless()) {
+			return errors.New("sharing the host's PID namespace is not permitted when running rootless: /proc can't be mounted for it from inside of a user namespace")
+		}
+	}
 	switch isolation {
 	case IsolationOCIRootless:
 		if ns := options.NamespaceOptions.Find(string(specs.IPCNamespace)); ns == nil || ns.Host {
@@ -140,3 +140,24 @@ load helpers
   expect_output --substring "755"
   run_buildah rm $newcid
 }
+
+@test "add-url-checksum" {
+  createrandom ${TESTDIR}/randomfile
+  checksum=sha256:$(sha256sum ${TESTDIR}/randomfile | cut -f1 -d' ')
+  wrong=sha256:$(echo wrong | sha256sum | cut -f1 -d' ')
+
+  cid=$(buildah from --signature-policy ${TESTSDIR}/policy.json scratch)
+  starthttpd ${TESTDIR}
+  run_buildah add --checksum ${checksum} $cid http://0.0.0.0:${HTTP_SERVER_PORT}/randomfile /urlfile
+  run_buildah 1 add --checksum ${wrong} $cid http://0.0.0.0:${HTTP_SERVER_PORT}/randomfile /wrongfile
+  expect_output --substring "checksum did not match"
+  run_buildah 1 add --checksum md5:0123456789abcdef0123456789abcdef $cid http://0.0.0.0:${HTTP_SERVER_PORT}/randomfile /md5file
+  run_buildah 1 add --checksum ${checksum} $cid ${TESTDIR}/randomfile /localfile
+  expect_output --substring "can only be verified for URLs"
+  stophttpd
+  root=$(buildah mount $cid)
+  cmp ${TESTDIR}/randomfile $root/urlfile
+  run test -e $root/wrongfile
+  [ "$status" -ne 0 ]
+  buildah rm $cid
+}
@@ -39,8 +39,41 @@ EOM
   # bud test this should work
   run_buildah bud -f ./Dockerfile --signature-policy ${TESTSDIR}/policy.json --tls-verify=false --creds=testuser:testpassword .
 
+  # bud test pulling without TLS verification but writing with it should fail
+  run_buildah 1 bud -f ./Dockerfile --signature-policy ${TESTSDIR}/policy.json --src-tls-verify=false --dest-tls-verify=true --creds=testuser:testpassword -t docker://localhost:5000/my-alpine-built .
+
+  # bud test pulling and writing without TLS verification should work
+  run_buildah bud -f ./Dockerfile --signature-policy ${TESTSDIR}/policy.json --src-tls-verify=false --dest-tls-verify=false --creds=testuser:testpassword -t docker://localhost:5000/my-alpine-built .
+
   # Clean up
   rm -f ./Dockerfile
   buildah rm -a
   buildah rmi -f --all
 }
+
+@test "authfile-from-env" {
+  run_buildah from --pull --name "alpine" --signature-policy ${TESTSDIR}/policy.json alpine
+
+  # Missing or malformed authentication information should be caught early.
+  unset BUILDAH_TEST_AUTH
+  run_buildah 1 push --signature-policy ${TESTSDIR}/policy.json --tls-verify=false --authfile env:BUILDAH_TEST_AUTH alpine localhost:5000/my-alpine
+  expect_output --substring "BUILDAH_TEST_AUTH.*is not set"
+  export BUILDAH_TEST_AUTH='{"auths":'
+  run_buildah 1 push --signature-policy ${TESTSDIR}/policy.json --tls-verify=false --authfile env:BUILDAH_TEST_AUTH alpine localhost:5000/my-alpine
+  expect_output --substring "error parsing authentication information in environment variable"
+
+  # Bad credentials should fail, good ones should work.
+  export BUILDAH_TEST_AUTH="{\"auths\":{\"localhost:5000\":{\"auth\":\"$(echo -n testuser:badpassword | base64)\"}}}"
+  run_buildah 1 push --signature-policy ${TESTSDIR}/policy.json --tls-verify=false --authfile env:BUILDAH_TEST_AUTH alpine localhost:5000/my-alpine
+  export BUILDAH_TEST_AUTH="{\"auths\":{\"localhost:5000\":{\"auth\":\"$(echo -n testuser:testpassword | base64)\"}}}"
+  run_buildah push --signature-policy ${TESTSDIR}/policy.json --tls-verify=false --authfile env:BUILDAH_TEST_AUTH alpine localhost:5000/my-alpine
+  REGISTRY_AUTH_FILE=env:BUILDAH_TEST_AUTH run_buildah pull --signature-policy ${TESTSDIR}/policy.json --tls-verify=false localhost:5000/my-alpine
+
+  # Credentials can't be saved to the environment.
+  run_buildah 1 login --authfile env:BUILDAH_TEST_AUTH --username testuser --password testpassword localhost:5000
+  expect_output --substring "can not be saved to an environment variable"
+
+  unset BUILDAH_TEST_AUTH
+  buildah rm -a
+  buildah rmi -f --all
+}
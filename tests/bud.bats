@@ -112,6 +112,34 @@ load helpers
   buildah rmi -a -f
 }
 
+@test "bud with --layers and --no-cache-stage" {
+  cp -a ${TESTSDIR}/bud/use-layers ${TESTDIR}/use-layers
+
+  mkdir -p ${TESTDIR}/use-layers/uuid
+  uuidgen > ${TESTDIR}/use-layers/uuid/data
+  mkdir -p ${TESTDIR}/use-layers/date
+  date > ${TESTDIR}/use-layers/date/data
+
+  buildah bud --signature-policy ${TESTSDIR}/policy.json --layers -t test1 -f Dockerfile.multistage-copy ${TESTDIR}/use-layers
+  run_buildah --debug=false images -a
+  expect_line_count 6
+
+  # The "uuid" stage gets rebuilt, but the "date" stage's layer gets reused.
+  run_buildah bud --signature-policy ${TESTSDIR}/policy.json --layers --no-cache-stage uuid -t test2 -f Dockerfile.multistage-copy ${TESTDIR}/use-layers
+  expect_output --substring "Using cache"
+  run_buildah --debug=false images -a
+  [ $(wc -l <<< "$output") -gt 6 ]
+
+  # The unnamed final stage can be referred to by number.
+  run_buildah bud --signature-policy ${TESTSDIR}/policy.json --layers --no-cache-stage 2 -t test3 -f Dockerfile.multistage-copy ${TESTDIR}/use-layers
+  expect_output --substring "Using cache"
+
+  run_buildah 1 bud --signature-policy ${TESTSDIR}/policy.json --layers --no-cache-stage nosuchstage -t test4 -f Dockerfile.multistage-copy ${TESTDIR}/use-layers
+  expect_output --substring 'caching was disabled for stage "nosuchstage"'
+
+  buildah rmi -a -f
+}
+
 @test "bud with --layers, multistage, and COPY with --from" {
   cp -a ${TESTSDIR}/bud/use-layers ${TESTDIR}/use-layers
 
@@ -329,6 +357,17 @@ load helpers
   buildah rmi ${target}
 }
 
+@test "bud-from-scratch-label-from-cmd" {
+  target=scratch-image
+  buildah bud --label-from-cmd "test=echo   label-from-cmd  " --signature-policy ${TESTSDIR}/policy.json -t ${target} ${TESTSDIR}/bud/from-scratch
+  run_buildah --debug=false inspect --format '{{printf "%q" .Docker.Config.Labels}}' ${target}
+  expect_output 'map["test":"label-from-cmd"]'
+  buildah rmi ${target}
+
+  run_buildah 1 bud --label-from-cmd 'test=false' --signature-policy ${TESTSDIR}/policy.json -t ${target} ${TESTSDIR}/bud/from-scratch
+  expect_output --substring 'error running "false" to compute the value of label "test"'
+}
+
 @test "bud-from-scratch-annotation" {
   target=scratch-image
   buildah bud --annotation "test=annotation1,annotation2=z" --signature-policy ${TESTSDIR}/policy.json -t ${target} ${TESTSDIR}/bud/from-scratch
@@ -737,6 +776,18 @@ load helpers
   expect_output ""
 }
 
+@test "bud-shell default overridden with --shell" {
+  target=ubuntu-image
+  run_buildah bud --shell "/bin/bash -c" --signature-policy ${TESTSDIR}/policy.json -t ${target} -f ${TESTSDIR}/bud/shell/Dockerfile.build-shell-default-ubuntu ${TESTSDIR}/bud/shell
+  expect_output --substring "SHELL=/bin/bash"
+  # A SHELL instruction still takes precedence over --shell.
+  run_buildah bud --shell "/bin/dash -c" --signature-policy ${TESTSDIR}/policy.json -t ${target} -f ${TESTSDIR}/bud/shell/Dockerfile.build-shell-custom ${TESTSDIR}/bud/shell
+  expect_output --substring "SHELL=/bin/bash"
+  buildah rmi -a
+  run_buildah --debug=false images -q
+  expect_output ""
+}
+
 @test "bud with symlinks" {
   target=alpine-image
   run_buildah bud --signature-policy ${TESTSDIR}/policy.json -t ${target} ${TESTSDIR}/bud/symlink
@@ -1035,8 +1086,13 @@ load helpers
 @test "bud-logfile" {
   rm -f ${TESTDIR}/logfile
   run_buildah bud --logfile ${TESTDIR}/logfile --signature-policy ${TESTSDIR}/policy.json ${TESTSDIR}/bud/preserve-volumes
-  expect_output ""
+  # Only the steps and the image ID go to standard output.
+  expect_output --substring "STEP 1: FROM alpine"
+  [[ ! "$output" =~ "Getting image source signatures" ]]
   test -s ${TESTDIR}/logfile
+  run cat ${TESTDIR}/logfile
+  expect_output --substring "STEP 1: FROM alpine"
+  expect_output --substring "Getting image source signatures"
 }
 
 @test "bud with ARGS" {
@@ -1572,3 +1628,188 @@ load helpers
   run_buildah --debug=false images -q
   expect_line_count 2 
 }
+
+@test "bud-sigterm-cleans-up" {
+  mkdir -p ${TESTDIR}/sigterm
+  cat > ${TESTDIR}/sigterm/Dockerfile << _EOF
+FROM alpine
+RUN sleep 300
+_EOF
+  buildah bud --signature-policy ${TESTSDIR}/policy.json -t sigterm-image ${TESTDIR}/sigterm &
+  pid=$!
+  # Wait for the RUN instruction's container to be created.
+  for i in $(seq 60) ; do
+    run_buildah --debug=false containers -q
+    if test -n "$output" ; then
+      break
+    fi
+    sleep 1
+  done
+  sleep 5
+  kill -TERM $pid
+  run wait $pid
+  [ "$status" -ne 0 ]
+  run_buildah --debug=false containers -q
+  expect_output ""
+  run_buildah --debug=false mount
+  expect_output ""
+}
+
+@test "bud-provenance" {
+  target=provenance-image
+  run_buildah bud --signature-policy ${TESTSDIR}/policy.json --provenance ${TESTDIR}/provenance.json --build-arg FOO=bar -t ${target} -f ${TESTSDIR}/bud/from-multiple-files/Dockerfile1.alpine ${TESTSDIR}/bud/from-multiple-files
+  run_buildah --debug=false inspect --format '{{.FromImageID}}' alpine
+  alpineid="$output"
+  run_buildah --debug=false images -q --no-trunc ${target}
+  imageid="${output#sha256:}"
+  run cat ${TESTDIR}/provenance.json
+  echo "$output"
+  expect_output --substring '"_type": "https://in-toto.io/Statement/v0.1"'
+  expect_output --substring '"predicateType": "https://slsa.dev/provenance/v0.2"'
+  expect_output --substring "\"sha256\": \"${imageid}\""
+  expect_output --substring "\"sha256\": \"${alpineid}\""
+  expect_output --substring '"FOO": "bar"'
+  expect_output --substring 'Dockerfile1.alpine'
+  buildah rmi -a
+}
+
+@test "bud-run-mount-from-stage" {
+  target=run-mount-image
+  run_buildah bud --signature-policy ${TESTSDIR}/policy.json -t ${target} ${TESTSDIR}/bud/run-mount
+  ctr=$(buildah --debug=false from --signature-policy ${TESTSDIR}/policy.json ${target})
+  mnt=$(buildah --debug=false mount ${ctr})
+  run cat $mnt/greeting
+  expect_output "hello"
+  run test -e $mnt/in/greeting
+  [ "$status" -ne 0 ]
+  buildah rm ${ctr}
+  buildah rmi -a
+}
+
+@test "bud-containerfile-outside-context-dir" {
+  target=context-dir-image
+  # COPY sources come from the context directory, not the Containerfile's directory.
+  run_buildah bud --signature-policy ${TESTSDIR}/policy.json -t ${target} -f ${TESTSDIR}/bud/containerfile-outside-context/build/Containerfile --context-dir ${TESTSDIR}/bud/containerfile-outside-context/context
+  ctr=$(buildah --debug=false from --signature-policy ${TESTSDIR}/policy.json ${target})
+  mnt=$(buildah --debug=false mount ${ctr})
+  run cat $mnt/file.txt
+  expect_output "context"
+  run cat $mnt/subdir/other.txt
+  expect_output "other"
+  buildah rm ${ctr}
+  buildah rmi ${target}
+
+  # A relative Containerfile path is found relative to the current directory.
+  cd ${TESTSDIR}/bud/containerfile-outside-context
+  run_buildah bud --signature-policy ${TESTSDIR}/policy.json -t ${target} -f build/Containerfile context
+  ctr=$(buildah --debug=false from --signature-policy ${TESTSDIR}/policy.json ${target})
+  mnt=$(buildah --debug=false mount ${ctr})
+  run cat $mnt/file.txt
+  expect_output "context"
+  buildah rm ${ctr}
+  buildah rmi ${target}
+
+  run_buildah 1 bud --signature-policy ${TESTSDIR}/policy.json -t ${target} -f build/Containerfile --context-dir context context
+  expect_output --substring "context directory specified both"
+}
+
+@test "bud-embed-containerfile" {
+  target=embed-label-image
+  run_buildah bud --signature-policy ${TESTSDIR}/policy.json --embed-containerfile label --build-arg SECRET=hunter2 -t ${target} ${TESTSDIR}/bud/embed-containerfile
+  run_buildah --debug=false inspect --format '{{index .Docker.Config.Labels "io.buildah.containerfile"}}' ${target}
+  expect_output "$(cat ${TESTSDIR}/bud/embed-containerfile/Dockerfile)"
+  [[ ! "$output" =~ hunter2 ]]
+
+  target=embed-file-image
+  run_buildah bud --signature-policy ${TESTSDIR}/policy.json --layers --embed-containerfile /usr/share/buildah/Containerfile --build-arg SECRET=hunter2 -t ${target} ${TESTSDIR}/bud/embed-containerfile
+  ctr=$(buildah --debug=false from --signature-policy ${TESTSDIR}/policy.json ${target})
+  mnt=$(buildah --debug=false mount ${ctr})
+  cmp ${TESTSDIR}/bud/embed-containerfile/Dockerfile $mnt/usr/share/buildah/Containerfile
+  run grep hunter2 $mnt/usr/share/buildah/Containerfile
+  [ "$status" -ne 0 ]
+  buildah rm ${ctr}
+
+  run_buildah 1 bud --signature-policy ${TESTSDIR}/policy.json --embed-containerfile relative/path -t ${target} ${TESTSDIR}/bud/embed-containerfile
+  expect_output --substring "must be \"label\" or an absolute path"
+  buildah rmi -a
+}
+
+@test "bud-copy-from-later-stage" {
+  run_buildah 1 bud --signature-policy ${TESTSDIR}/policy.json ${TESTSDIR}/bud/copy-from-later-stage
+  expect_output --substring 'COPY --from=second: stage "second" has not been built yet'
+  run_buildah 1 bud --signature-policy ${TESTSDIR}/policy.json -f ${TESTSDIR}/bud/copy-from-later-stage/Dockerfile.self ${TESTSDIR}/bud/copy-from-later-stage
+  expect_output --substring 'stage "only" can not refer to itself'
+  buildah rmi -a
+}
+
+@test "bud-add-url-checksum" {
+  createrandom ${TESTDIR}/randomfile
+  checksum=sha512:$(sha512sum ${TESTDIR}/randomfile | cut -f1 -d' ')
+  starthttpd ${TESTDIR}
+  mkdir -p ${TESTDIR}/context
+  cat > ${TESTDIR}/context/Dockerfile << _EOF
+FROM scratch
+ADD --checksum=${checksum} http://0.0.0.0:${HTTP_SERVER_PORT}/randomfile /randomfile
+_EOF
+  run_buildah bud --signature-policy ${TESTSDIR}/policy.json -t checksum-image ${TESTDIR}/context
+  wrong=sha512:$(echo wrong | sha512sum | cut -f1 -d' ')
+  sed -i -e "s,${checksum},${wrong}," ${TESTDIR}/context/Dockerfile
+  run_buildah 1 bud --signature-policy ${TESTSDIR}/policy.json -t checksum-image-bad ${TESTDIR}/context
+  expect_output --substring "checksum did not match"
+  stophttpd
+  buildah rmi -a
+}
+
+@test "bud-require-build-arg" {
+  target=require-build-arg
+  # Every missing argument should be listed in one error.
+  run_buildah 1 bud --signature-policy ${TESTSDIR}/policy.json -t ${target} --require-build-arg FLAVOR --require-build-arg RELEASE --require-build-arg UNDECLARED ${TESTSDIR}/bud/require-build-arg
+  expect_output --substring "required build arguments .*: FLAVOR, RELEASE, UNDECLARED"
+  # Arguments with default values in the Dockerfile are fine, even if empty.
+  run_buildah 1 bud --signature-policy ${TESTSDIR}/policy.json -t ${target} --require-build-arg BASE --require-build-arg EMPTY --require-build-arg FLAVOR --build-arg RELEASE=1 ${TESTSDIR}/bud/require-build-arg
+  expect_output --substring "required build arguments .*: FLAVOR$"
+  run_buildah bud --signature-policy ${TESTSDIR}/policy.json -t ${target} --require-build-arg BASE --require-build-arg EMPTY --require-build-arg FLAVOR --build-arg FLAVOR=vanilla --build-arg RELEASE=1 ${TESTSDIR}/bud/require-build-arg
+  expect_output --substring "vanilla 1"
+  buildah rmi ${target}
+}
+
+@test "bud-run-mount-context-rw" {
+  target=run-mount-rw-image
+  # Work on a copy of the context directory, since we're going to write to it.
+  cp -a ${TESTSDIR}/bud/run-mount-rw ${TESTDIR}/context
+  echo input > ${TESTDIR}/context/input
+  run_buildah bud --signature-policy ${TESTSDIR}/policy.json --layers -t ${target} ${TESTDIR}/context
+  run cat ${TESTDIR}/context/generated
+  expect_output "input
+generated"
+  # The instruction has to run again, even though a cached image for it exists.
+  rm -f ${TESTDIR}/context/generated
+  run_buildah bud --signature-policy ${TESTSDIR}/policy.json --layers -t ${target} ${TESTDIR}/context
+  test -s ${TESTDIR}/context/generated
+  test ! -e ${TESTDIR}/context/not-allowed
+  # Only the build context can be mounted read-write.
+  run_buildah 1 bud --signature-policy ${TESTSDIR}/policy.json -t ${target} -f ${TESTDIR}/context/Dockerfile.from ${TESTDIR}/context
+  expect_output --substring "only the build context can be mounted read-write"
+  buildah rmi -a -f
+}
+
+@test "bud with --layers and --print-cache-keys" {
+  run_buildah 1 bud --signature-policy ${TESTSDIR}/policy.json --print-cache-keys -t test -f Dockerfile.2 ${TESTSDIR}/bud/use-layers
+  expect_output --substring "cache keys can only be printed when intermediate images are being cached using --layers"
+
+  run_buildah bud --signature-policy ${TESTSDIR}/policy.json --layers --print-cache-keys -t test -f Dockerfile.2 ${TESTSDIR}/bud/use-layers
+  expect_output --substring "Cache key for stage 0 line 2: sha256:[0-9a-f]{64} miss"
+  expect_output --substring "Cache key for stage 0 line 3: sha256:[0-9a-f]{64} not checked: an earlier instruction was not found in the cache"
+  key=$(grep "line 3:" <<< "$output" | awk '{print $9}')
+
+  # The same instructions on top of the same base should produce the same keys.
+  run_buildah bud --signature-policy ${TESTSDIR}/policy.json --layers --print-cache-keys -t test -f Dockerfile.2 ${TESTSDIR}/bud/use-layers
+  expect_output --substring "Cache key for stage 0 line 2: sha256:[0-9a-f]{64} hit [0-9a-f]{64}"
+  expect_output --substring "Cache key for stage 0 line 3: $key hit [0-9a-f]{64}"
+  expect_output --substring "Cache key for stage 0 line 4: sha256:[0-9a-f]{64} hit [0-9a-f]{64}"
+
+  run_buildah bud --signature-policy ${TESTSDIR}/policy.json --layers --no-cache --print-cache-keys -t test -f Dockerfile.2 ${TESTSDIR}/bud/use-layers
+  expect_output --substring "Cache key for stage 0 line 2: sha256:[0-9a-f]{64} not checked: caching is disabled"
+
+  buildah rmi -a -f
+}
@@ -43,6 +43,16 @@ load helpers
   buildah rmi -a
 }
 
+@test "commit label-from-cmd test" {
+  cid=$(buildah from --pull --signature-policy ${TESTSDIR}/policy.json alpine)
+  buildah commit --label-from-cmd 'vcs-ref=echo $(not-interpolated)' --signature-policy ${TESTSDIR}/policy.json $cid alpine-image
+  run_buildah --debug=false inspect --type=image --format '{{index .Docker.Config.Labels "vcs-ref"}}' alpine-image
+  expect_output '$(not-interpolated)'
+  run_buildah 1 commit --label-from-cmd 'vcs-ref=false' --signature-policy ${TESTSDIR}/policy.json $cid alpine-image
+  buildah rm $cid
+  buildah rmi -a
+}
+
 @test "commit rm test" {
   cid=$(buildah from --pull --signature-policy ${TESTSDIR}/policy.json alpine)
   buildah commit --signature-policy ${TESTSDIR}/policy.json --rm $cid alpine-image
@@ -96,3 +106,29 @@ load helpers
   cid=$(buildah from --pull --signature-policy ${TESTSDIR}/policy.json alpine)
   run_buildah commit --signature-policy ${TESTSDIR}/policy.json $cid
 }
+
+@test "commit-record-config-changes" {
+  if ! python3 -c 'import json, sys' 2> /dev/null ; then
+    skip "python interpreter with json module not found"
+  fi
+  target=new-image
+  cid=$(buildah from --pull --signature-policy ${TESTSDIR}/policy.json alpine)
+
+  run_buildah --debug=false config --label color=blue $cid
+  run_buildah --debug=false commit --signature-policy ${TESTSDIR}/policy.json --record-config-changes $cid ${target}
+  run_buildah --debug=false inspect --format '{{.Config}}' ${target}
+  config="$output"
+  run python3 -c 'import json, sys; config = json.load(sys.stdin); print(config["history"][len(config["history"])-1]["comment"])' <<< "$config"
+  echo "$output"
+  [ "${status}" -eq 0 ]
+  expect_output 'label "color" added with value "blue"'
+
+  # Without the flag, no entry is added.
+  run_buildah --debug=false commit --signature-policy ${TESTSDIR}/policy.json $cid ${target}
+  run_buildah --debug=false inspect --format '{{.Config}}' ${target}
+  config="$output"
+  run python3 -c 'import json, sys; config = json.load(sys.stdin); print(config["history"][len(config["history"])-1].get("comment", ""))' <<< "$config"
+  echo "$output"
+  [ "${status}" -eq 0 ]
+  expect_output ""
+}
@@ -192,6 +192,21 @@ function check_matrix() {
   buildah rmi env-image-docker env-image-oci
 }
 
+@test "user-verbatim" {
+  cid=$(buildah from --pull --signature-policy ${TESTSDIR}/policy.json alpine)
+  for user in 1000:1000 appuser appuser:appgroup 1000 ; do
+    buildah config --user $user $cid
+    buildah commit --signature-policy ${TESTSDIR}/policy.json $cid user-image-oci
+    buildah commit --format docker --signature-policy ${TESTSDIR}/policy.json $cid user-image-docker
+    run_buildah --debug=false inspect --type=image --format '{{.OCIv1.Config.User}}' user-image-oci
+    expect_output "$user"
+    run_buildah --debug=false inspect --type=image --format '{{.Docker.Config.User}}' user-image-docker
+    expect_output "$user"
+  done
+  buildah rm $cid
+  buildah rmi user-image-docker user-image-oci
+}
+
 @test "user" {
   cid=$(buildah from --pull --signature-policy ${TESTSDIR}/policy.json alpine)
   bndoutput=$(buildah --debug=false run $cid grep CapBnd /proc/self/status)
@@ -207,3 +222,29 @@ function check_matrix() {
 
   buildah rm $cid
 }
+
+@test "config-port-remove" {
+  cid=$(buildah from --pull=false --signature-policy ${TESTSDIR}/policy.json scratch)
+  buildah config --port 80 --port 53/udp --port 443/tcp $cid
+  buildah config --port 80/tcp- --port 53- --port 443- $cid
+  run_buildah --debug=false inspect --format '{{.OCIv1.Config.ExposedPorts}}' $cid
+  expect_output "map[53/udp:{}]"
+  run_buildah --debug=false inspect --format '{{.Docker.Config.ExposedPorts}}' $cid
+  expect_output "map[53/udp:{}]"
+  buildah rm $cid
+}
+
+@test "config-stop-signal-normalized" {
+  cid=$(buildah from --pull=false --signature-policy ${TESTSDIR}/policy.json scratch)
+  run_buildah config --stop-signal 15 $cid
+  run_buildah --debug=false inspect --format '{{.OCIv1.Config.StopSignal}} {{.Docker.Config.StopSignal}}' $cid
+  expect_output "SIGTERM SIGTERM"
+  run_buildah config --stop-signal rtmin+2 $cid
+  run_buildah --debug=false inspect --format '{{.OCIv1.Config.StopSignal}}' $cid
+  expect_output "SIGRTMIN+2"
+  run_buildah 1 config --stop-signal SIGBOGUS $cid
+  expect_output --substring 'invalid signal "SIGBOGUS"'
+  run_buildah --debug=false inspect --format '{{.OCIv1.Config.StopSignal}}' $cid
+  expect_output "SIGRTMIN+2"
+  buildah rm $cid
+}
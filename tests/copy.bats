@@ -169,3 +169,97 @@ load helpers
   cmp ${TESTDIR}/randomfile $newroot/link-randomfile
   buildah rm $newcid
 }
+
+@test "copy --chmod" {
+  mkdir -p ${TESTDIR}/subdir
+  createrandom ${TESTDIR}/randomfile
+  createrandom ${TESTDIR}/subdir/randomfile
+
+  cid=$(buildah from --pull --signature-policy ${TESTSDIR}/policy.json alpine)
+  buildah config --workingdir / $cid
+  buildah copy --chmod 0751 $cid ${TESTDIR}/randomfile /randomfile
+  test $(buildah run $cid stat -c "%a" /randomfile) = "751"
+  buildah copy --chmod 0700 $cid ${TESTDIR}/subdir /subdir
+  test $(buildah run $cid stat -c "%a" /subdir/randomfile) = "700"
+  run_buildah 1 copy --chmod 0999 $cid ${TESTDIR}/randomfile /randomfile
+  expect_output --substring "invalid file mode"
+}
+
+@test "copy --from" {
+  createrandom ${TESTDIR}/randomfile
+
+  src=$(buildah from --pull --signature-policy ${TESTSDIR}/policy.json alpine)
+  buildah copy $src ${TESTDIR}/randomfile /randomfile
+  dest=$(buildah from --pull --signature-policy ${TESTSDIR}/policy.json scratch)
+  buildah copy --from $src --chown 1:2 --chmod 0640 $dest /randomfile /copied
+  root=$(buildah mount $dest)
+  cmp ${TESTDIR}/randomfile $root/copied
+  test $(stat -c "%u:%g %a" $root/copied) = "1:2 640"
+  buildah copy --from alpine $dest /etc/alpine-release /alpine-release
+  test -s $root/alpine-release
+  buildah unmount $dest
+  buildah rm $src $dest
+}
+
+@test "copy-preserving-hardlinks" {
+  mkdir -p ${TESTDIR}/hardlinks/subdir
+  dd if=/dev/urandom bs=1024 count=1024 of=${TESTDIR}/hardlinks/file 2> /dev/null
+  ln ${TESTDIR}/hardlinks/file ${TESTDIR}/hardlinks/link1
+  ln ${TESTDIR}/hardlinks/file ${TESTDIR}/hardlinks/subdir/link2
+
+  # Links among multiple sources in a single copy should be preserved.
+  cid=$(buildah from --pull --signature-policy ${TESTSDIR}/policy.json scratch)
+  root=$(buildah mount $cid)
+  buildah copy $cid ${TESTDIR}/hardlinks/file ${TESTDIR}/hardlinks/link1 ${TESTDIR}/hardlinks/subdir/link2 /dest/
+  test $(stat -c %i ${root}/dest/file) = $(stat -c %i ${root}/dest/link1)
+  test $(stat -c %i ${root}/dest/file) = $(stat -c %i ${root}/dest/link2)
+  cmp ${TESTDIR}/hardlinks/file ${root}/dest/link2
+  buildah rm $cid
+
+  # Links within a directory should be preserved, even when we have to
+  # check each item in it against a .dockerignore file.
+  cat > ${TESTDIR}/hardlinks/Dockerfile << _EOF
+FROM scratch
+COPY . /dest/
+_EOF
+  echo Dockerfile > ${TESTDIR}/hardlinks/.dockerignore
+  run_buildah bud --signature-policy ${TESTSDIR}/policy.json -t hardlinks ${TESTDIR}/hardlinks
+  cid=$(buildah from --signature-policy ${TESTSDIR}/policy.json hardlinks)
+  root=$(buildah mount $cid)
+  test $(stat -c %h ${root}/dest/file) -eq 3
+  test $(stat -c %i ${root}/dest/file) = $(stat -c %i ${root}/dest/subdir/link2)
+  # The layer should hold one copy of the contents, not three.
+  size=$(du -sk ${root}/dest | cut -f1)
+  test $size -lt 2048
+  buildah rm $cid
+  buildah rmi hardlinks
+}
+
+@test "copy --chown-source" {
+  createrandom ${TESTDIR}/randomfile
+  # A scratch container has no /etc/passwd or /etc/group for us to consult.
+  cid=$(buildah from --pull --signature-policy ${TESTSDIR}/policy.json scratch)
+  root=$(buildah mount $cid)
+  run_buildah 1 copy --chown root:root $cid ${TESTDIR}/randomfile /randomfile
+  run_buildah 1 copy --chown root:root --chown-source bogus $cid ${TESTDIR}/randomfile /randomfile
+  expect_output --substring 'invalid source "bogus"'
+  buildah copy --chown root:root --chown-source host $cid ${TESTDIR}/randomfile /randomfile
+  run stat -c "%u:%g" ${root}/randomfile
+  expect_output "$(id -u root):$(id -g root)"
+  buildah rm $cid
+
+  # The same goes for COPY --chown in a Dockerfile.
+  mkdir -p ${TESTDIR}/chown-source
+  cp ${TESTDIR}/randomfile ${TESTDIR}/chown-source/
+  cat > ${TESTDIR}/chown-source/Dockerfile << _EOF
+FROM scratch
+COPY --chown=root:root randomfile /
+_EOF
+  run_buildah 1 bud --signature-policy ${TESTSDIR}/policy.json -t chown-source ${TESTDIR}/chown-source
+  run_buildah bud --signature-policy ${TESTSDIR}/policy.json --chown-source host -t chown-source ${TESTDIR}/chown-source
+  cid=$(buildah from --signature-policy ${TESTSDIR}/policy.json chown-source)
+  root=$(buildah mount $cid)
+  cmp ${TESTDIR}/randomfile ${root}/randomfile
+  buildah rm $cid
+  buildah rmi chown-source
+}
@@ -235,6 +235,24 @@ load helpers
   buildah rm $cid
 }
 
+@test "from cpu-rt-period and cpu-rt-runtime test" {
+  if test "$BUILDAH_ISOLATION" = "chroot" -o "$BUILDAH_ISOLATION" = "rootless" ; then
+    skip "BUILDAH_ISOLATION = $BUILDAH_ISOLATION"
+  fi
+  if ! which runc ; then
+    skip "no runc in PATH"
+  fi
+  if ! test -e /sys/fs/cgroup/cpu/cpu.rt_runtime_us ; then
+    skip "realtime group scheduling is not supported"
+  fi
+  cid=$(buildah from --cpu-rt-period=50000 --cpu-rt-runtime=0 --pull --signature-policy ${TESTSDIR}/policy.json alpine)
+  run_buildah --debug=false run $cid cat /sys/fs/cgroup/cpu/cpu.rt_period_us
+  expect_output "50000"
+  run_buildah --debug=false run --cpu-rt-period=40000 $cid cat /sys/fs/cgroup/cpu/cpu.rt_period_us
+  expect_output "40000"
+  buildah rm $cid
+}
+
 @test "from cpu-shares test" {
   if test "$BUILDAH_ISOLATION" = "chroot" -o "$BUILDAH_ISOLATION" = "rootless" ; then
     skip "BUILDAH_ISOLATION = $BUILDAH_ISOLATION"
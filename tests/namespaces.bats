@@ -296,6 +296,19 @@ general_namespace() {
   general_namespace pid
 }
 
+@test "pid-namespace-host-rootless" {
+  if test "$BUILDAH_ISOLATION" = "chroot" ; then
+    skip "BUILDAH_ISOLATION = $BUILDAH_ISOLATION"
+  fi
+  mkdir -p $TESTDIR/no-cni-configs
+  RUNOPTS="--cni-config-dir=${TESTDIR}/no-cni-configs ${RUNC_BINARY:+--runtime $RUNC_BINARY}"
+  run_buildah --debug=false from --signature-policy ${TESTSDIR}/policy.json --quiet --pid=host alpine
+  ctr="$output"
+  run_buildah 1 --debug=false run $RUNOPTS --isolation=rootless "$ctr" true
+  expect_output --substring "sharing the host's PID namespace is not permitted when running rootless"
+  run_buildah --debug=false run $RUNOPTS --isolation=rootless --pid=container "$ctr" true
+}
+
 @test "user-namespace" {
   if test "$BUILDAH_ISOLATION" = "chroot" -o "$BUILDAH_ISOLATION" = "rootless" ; then
     skip "BUILDAH_ISOLATION = $BUILDAH_ISOLATION"
@@ -133,6 +133,13 @@ load helpers
   [ $(wc -l <<< "$output") -ge 3 ]
 }
 
+@test "pull-max-parallel-downloads" {
+  run_buildah pull --registries-conf ${TESTSDIR}/registries.conf --signature-policy ${TESTSDIR}/policy.json --max-parallel-downloads 1 quay.io/libpod/alpine_nginx:latest
+  run_buildah images --format "{{.Name}}:{{.Tag}}"
+  expect_output --substring "alpine_nginx:latest"
+  buildah rmi -a
+}
+
 @test "pull-from-oci-directory" {
   run_buildah pull --signature-policy ${TESTSDIR}/policy.json alpine
   run_buildah push --signature-policy ${TESTSDIR}/policy.json docker.io/library/alpine:latest oci:${TESTDIR}/alpine
@@ -148,4 +155,6 @@ load helpers
 
 @test "pull-with-alltags-from-registry" {
   run_buildah pull --all-tags --registries-conf ${TESTSDIR}/registries.conf --signature-policy ${TESTSDIR}/policy.json quay.io/libpod/alpine_nginx
+  expect_output --substring "Pulling quay.io/libpod/alpine_nginx:latest \([0-9]+ of [0-9]+\)"
+  expect_output --substring "Pulled [0-9]+ of [0-9]+ tags from quay.io/libpod/alpine_nginx"
 }
@@ -258,6 +258,28 @@ function configure_and_check_user() {
 	run_buildah --debug=false run -v ${TESTDIR}/was-empty/testfile:/var/different-multi-level/subdirectory/testfile        $cid touch /var/different-multi-level/subdirectory/testfile
 }
 
+@test "run --volume with U" {
+	if ! which runc ; then
+		skip "no runc in PATH"
+	fi
+	zflag=
+	if which selinuxenabled > /dev/null 2> /dev/null ; then
+		if selinuxenabled ; then
+			zflag=z
+		fi
+	fi
+	cid=$(buildah from --pull --signature-policy ${TESTSDIR}/policy.json alpine)
+	mkdir -p ${TESTDIR}/chown-me/subdir
+	touch ${TESTDIR}/chown-me/subdir/testfile
+	# Without U, the user can't write to the directory.
+	run_buildah 1 --debug=false run --user 1000:1000 -v ${TESTDIR}/chown-me:/var/chowned${zflag:+:${zflag}} $cid touch /var/chowned/newfile
+	run_buildah --debug=false run --user 1000:1000 -v ${TESTDIR}/chown-me:/var/chowned:U${zflag:+,${zflag}} $cid touch /var/chowned/newfile
+	run stat -c "%u:%g" ${TESTDIR}/chown-me/subdir/testfile
+	expect_output "1000:1000"
+	# The option can only be given once.
+	run_buildah 1 --debug=false run -v ${TESTDIR}/chown-me:/var/chowned:U,U $cid true
+}
+
 @test "run --mount" {
 	if ! which runc ; then
 		skip "no runc in PATH"
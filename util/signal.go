@@ -0,0 +1,137 @@
+package util
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	// sigrtmin and sigrtmax are the numbers of the lowest and highest
+	// real-time signals which are available to processes in containers.
+	sigrtmin = 34
+	sigrtmax = 64
+)
+
+// signalNames maps the numbers of signals which containers receive to their
+// canonical names.  The numbers are the ones used on Linux, where containers
+// run, regardless of the platform that we're running on.
+var signalNames = map[int]string{
+	1:  "SIGHUP",
+	2:  "SIGINT",
+	3:  "SIGQUIT",
+	4:  "SIGILL",
+	5:  "SIGTRAP",
+	6:  "SIGABRT",
+	7:  "SIGBUS",
+	8:  "SIGFPE",
+	9:  "SIGKILL",
+	10: "SIGUSR1",
+	11: "SIGSEGV",
+	12: "SIGUSR2",
+	13: "SIGPIPE",
+	14: "SIGALRM",
+	15: "SIGTERM",
+	16: "SIGSTKFLT",
+	17: "SIGCHLD",
+	18: "SIGCONT",
+	19: "SIGSTOP",
+	20: "SIGTSTP",
+	21: "SIGTTIN",
+	22: "SIGTTOU",
+	23: "SIGURG",
+	24: "SIGXCPU",
+	25: "SIGXFSZ",
+	26: "SIGVTALRM",
+	27: "SIGPROF",
+	28: "SIGWINCH",
+	29: "SIGIO",
+	30: "SIGPWR",
+	31: "SIGSYS",
+}
+
+// signalAliases maps alternate names for signals to their numbers.
+var signalAliases = map[string]int{
+	"SIGIOT":    6,
+	"SIGCLD":    17,
+	"SIGPOLL":   29,
+	"SIGUNUSED": 31,
+}
+
+// signalName returns the canonical name of the signal with the specified
+// number, or an empty string if there is no such signal.
+func signalName(number int) string {
+	switch {
+	case number == sigrtmin:
+		return "SIGRTMIN"
+	case number == sigrtmax:
+		return "SIGRTMAX"
+	case number > sigrtmin && number < sigrtmax:
+		return fmt.Sprintf("SIGRTMIN+%d", number-sigrtmin)
+	}
+	return signalNames[number]
+}
+
+// signalNumber returns the number of the signal with the specified name, which
+// must be in upper case and start with "SIG", or 0 if there is no such signal.
+func signalNumber(name string) int {
+	for number, candidate := range signalNames {
+		if candidate == name {
+			return number
+		}
+	}
+	if number, ok := signalAliases[name]; ok {
+		return number
+	}
+	for _, base := range []struct {
+		prefix string
+		number int
+		sign   int
+	}{
+		{"SIGRTMIN", sigrtmin, 1},
+		{"SIGRTMAX", sigrtmax, -1},
+	} {
+		if !strings.HasPrefix(name, base.prefix) {
+			continue
+		}
+		offset := strings.TrimPrefix(name, base.prefix)
+		if offset == "" {
+			return base.number
+		}
+		if (base.sign > 0 && offset[0] != '+') || (base.sign < 0 && offset[0] != '-') {
+			return 0
+		}
+		n, err := strconv.Atoi(offset[1:])
+		if err != nil || n < 0 || n > sigrtmax-sigrtmin {
+			return 0
+		}
+		return base.number + base.sign*n
+	}
+	return 0
+}
+
+// NormalizeSignal validates a signal, which can be specified either by number
+// or by name, with or without a "SIG" prefix and in any case, and returns its
+// canonical name, e.g. "SIGTERM" for "15", "term", or "SIGTERM".  An empty
+// value is returned unchanged.
+func NormalizeSignal(signal string) (string, error) {
+	if signal == "" {
+		return "", nil
+	}
+	if number, err := strconv.Atoi(signal); err == nil {
+		if name := signalName(number); name != "" {
+			return name, nil
+		}
+		return "", errors.Errorf("invalid signal number %d", number)
+	}
+	name := strings.ToUpper(signal)
+	if !strings.HasPrefix(name, "SIG") {
+		name = "SIG" + name
+	}
+	if number := signalNumber(name); number != 0 {
+		return signalName(number), nil
+	}
+	return "", errors.Errorf("invalid signal %q", signal)
+}